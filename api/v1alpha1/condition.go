@@ -0,0 +1,117 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionType is the type of an environment condition
+type ConditionType string
+
+const (
+	// ConditionReady is true once the cluster is provisioned and all applications are synced and healthy
+	ConditionReady ConditionType = "Ready"
+	// ConditionClusterProvisioned is true once the cluster can receive applications
+	ConditionClusterProvisioned ConditionType = "ClusterProvisioned"
+	// ConditionNodePoolReady is true once the node pools of the cluster are running
+	ConditionNodePoolReady ConditionType = "NodePoolReady"
+	// ConditionSourceSynced is true once the source application is synced and healthy
+	ConditionSourceSynced ConditionType = "SourceSynced"
+	// ConditionDependenciesHealthy is true once all dependency applications are synced and healthy
+	ConditionDependenciesHealthy ConditionType = "DependenciesHealthy"
+	// ConditionTTLExpiring is true when the environment is about to be deleted because of its TTL
+	ConditionTTLExpiring ConditionType = "TTLExpiring"
+	// ConditionTemplateUpToDate is false when the EnvironmentTemplate changed after the environment was rendered from it
+	ConditionTemplateUpToDate ConditionType = "TemplateUpToDate"
+)
+
+// Condition describes one aspect of the observed state of an environment.
+// It mirrors metav1.Condition, which isn't available in the apimachinery version used here.
+type Condition struct {
+	// Type of the condition
+	Type ConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown
+	// +kubebuilder:validation:Enum=True;False;Unknown
+	Status metav1.ConditionStatus `json:"status"`
+
+	// ObservedGeneration is the generation of the environment the condition was computed for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastTransitionTime is the last time the status of the condition changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+
+	// Reason is a CamelCase reason for the condition's last transition
+	Reason string `json:"reason"`
+
+	// Message is a human readable message with details about the transition
+	Message string `json:"message,omitempty"`
+}
+
+// SetCondition adds or updates the condition of the same type.
+// LastTransitionTime is only updated when the status changes.
+func SetCondition(conditions *[]Condition, newCondition Condition) {
+	existing := FindCondition(*conditions, newCondition.Type)
+	if existing == nil {
+		if newCondition.LastTransitionTime.IsZero() {
+			newCondition.LastTransitionTime = metav1.Now()
+		}
+		*conditions = append(*conditions, newCondition)
+		return
+	}
+
+	if existing.Status != newCondition.Status {
+		existing.Status = newCondition.Status
+		existing.LastTransitionTime = newCondition.LastTransitionTime
+		if existing.LastTransitionTime.IsZero() {
+			existing.LastTransitionTime = metav1.Now()
+		}
+	}
+
+	existing.Reason = newCondition.Reason
+	existing.Message = newCondition.Message
+	existing.ObservedGeneration = newCondition.ObservedGeneration
+}
+
+// FindCondition returns the condition of the given type or nil
+func FindCondition(conditions []Condition, conditionType ConditionType) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// RemoveCondition removes the condition of the given type
+func RemoveCondition(conditions *[]Condition, conditionType ConditionType) {
+	filtered := []Condition{}
+	for _, condition := range *conditions {
+		if condition.Type != conditionType {
+			filtered = append(filtered, condition)
+		}
+	}
+	*conditions = filtered
+}
+
+// IsConditionTrue returns true if the condition of the given type has status True
+func IsConditionTrue(conditions []Condition, conditionType ConditionType) bool {
+	condition := FindCondition(conditions, conditionType)
+	return condition != nil && condition.Status == metav1.ConditionTrue
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DependencyGraph returns the names of the dependencies each application of the environment
+// (the sources included) depends on, keyed by application name.
+// A source depends on all dependencies unless its dependsOn is set.
+func (in *Environment) DependencyGraph() (map[string][]string, error) {
+	graph := map[string][]string{}
+	for _, dependency := range in.Spec.Dependencies {
+		predecessors, err := in.resolveDependsOn(dependency.Name, dependency.DependsOn)
+		if err != nil {
+			return nil, err
+		}
+		graph[dependency.Name] = predecessors
+	}
+
+	for _, source := range in.Spec.SourceApplications() {
+		sourcePredecessors, err := in.resolveDependsOn(source.Name, source.DependsOn)
+		if err != nil {
+			return nil, err
+		}
+		if len(source.DependsOn) == 0 {
+			for _, dependency := range in.Spec.Dependencies {
+				sourcePredecessors = append(sourcePredecessors, dependency.Name)
+			}
+		}
+		graph[source.Name] = sourcePredecessors
+	}
+
+	return graph, nil
+}
+
+// DependencyOrder returns the names of the dependencies in the order they have to be deployed.
+// Dependencies that don't depend on each other keep the order of the spec.
+func (in *Environment) DependencyOrder() ([]string, error) {
+	graph, err := in.DependencyGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	order := []string{}
+	path := []string{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(path[indexOf(path, name):], name)
+			return fmt.Errorf("dependencies form a cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, predecessor := range graph[name] {
+			if err := visit(predecessor); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+
+		return nil
+	}
+
+	for _, dependency := range in.Spec.Dependencies {
+		if err := visit(dependency.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// resolveDependsOn maps the dependsOn entries of an application to dependency names
+func (in *Environment) resolveDependsOn(name string, dependsOn []string) ([]string, error) {
+	predecessors := []string{}
+	for _, ref := range dependsOn {
+		resolved := ""
+		for _, dependency := range in.Spec.Dependencies {
+			if dependency.Name == ref {
+				resolved = dependency.Name
+				break
+			}
+		}
+
+		if resolved == "" {
+			return nil, fmt.Errorf("'%s' depends on '%s' which is not a dependency of the environment", name, ref)
+		}
+		if resolved == name {
+			return nil, fmt.Errorf("'%s' depends on itself", name)
+		}
+		predecessors = append(predecessors, resolved)
+	}
+
+	return predecessors, nil
+}
+
+func indexOf(names []string, name string) int {
+	for i := range names {
+		if names[i] == name {
+			return i
+		}
+	}
+
+	return -1
+}
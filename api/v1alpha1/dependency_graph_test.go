@@ -0,0 +1,147 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newGraphEnvironment returns an environment with a source and the dependencies, each given as
+// its name followed by the names it depends on
+func newGraphEnvironment(dependencies ...[]string) *Environment {
+	env := &Environment{Spec: EnvironmentSpec{Source: &AppSrc{Name: "app"}}}
+	for _, dependency := range dependencies {
+		env.Spec.Dependencies = append(env.Spec.Dependencies, DependencySrc{Name: dependency[0], DependsOn: dependency[1:]})
+	}
+
+	return env
+}
+
+func TestDependencyGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     *Environment
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "source depends on every dependency",
+			env:  newGraphEnvironment([]string{"mysql"}, []string{"redis"}),
+			want: map[string][]string{
+				"mysql": {},
+				"redis": {},
+				"app":   {"mysql", "redis"},
+			},
+		},
+		{
+			name: "dependsOn of the source replaces the dependencies",
+			env: func() *Environment {
+				env := newGraphEnvironment([]string{"mysql"}, []string{"redis"})
+				env.Spec.Source.DependsOn = []string{"redis"}
+				return env
+			}(),
+			want: map[string][]string{
+				"mysql": {},
+				"redis": {},
+				"app":   {"redis"},
+			},
+		},
+		{
+			name:    "unknown dependency is rejected",
+			env:     newGraphEnvironment([]string{"api", "mysql"}),
+			wantErr: "'api' depends on 'mysql' which is not a dependency of the environment",
+		},
+		{
+			name:    "dependency on itself is rejected",
+			env:     newGraphEnvironment([]string{"mysql", "mysql"}),
+			wantErr: "'mysql' depends on itself",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := tt.env.DependencyGraph()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(graph, tt.want) {
+				t.Errorf("got graph %v, want %v", graph, tt.want)
+			}
+		})
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     *Environment
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "independent dependencies keep the order of the spec",
+			env:  newGraphEnvironment([]string{"mysql"}, []string{"redis"}, []string{"kafka"}),
+			want: []string{"mysql", "redis", "kafka"},
+		},
+		{
+			name: "dependencies are deployed after what they depend on",
+			env:  newGraphEnvironment([]string{"api", "mysql", "redis"}, []string{"mysql"}, []string{"redis", "mysql"}),
+			want: []string{"mysql", "redis", "api"},
+		},
+		{
+			name: "shared dependency is deployed once",
+			env:  newGraphEnvironment([]string{"api", "cache", "mysql"}, []string{"cache", "mysql"}, []string{"mysql"}),
+			want: []string{"mysql", "cache", "api"},
+		},
+		{
+			name:    "cycle is rejected",
+			env:     newGraphEnvironment([]string{"api", "mysql"}, []string{"mysql", "redis"}, []string{"redis", "api"}),
+			wantErr: "dependencies form a cycle: api -> mysql -> redis -> api",
+		},
+		{
+			name:    "cycle behind an acyclic dependency is rejected",
+			env:     newGraphEnvironment([]string{"kafka"}, []string{"api", "mysql"}, []string{"mysql", "api"}),
+			wantErr: "dependencies form a cycle: api -> mysql -> api",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := tt.env.DependencyOrder()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("got order %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
@@ -17,7 +17,15 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -28,9 +36,19 @@ type EnvironmentSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// +kubebuilder:validation:Required
-	// Source are parameters to define the main application
-	Source AppSrc `json:"source"`
+	// TemplateRef renders the spec from an EnvironmentTemplate when the environment is first reconciled.
+	// Fields set on the environment are kept, the empty ones are filled from the template.
+	// +optional
+	TemplateRef *TemplateRef `json:"templateRef,omitempty"`
+
+	// Source are parameters to define the main application.
+	// Deprecated: use sources, source is converted into the first of them.
+	// +optional
+	Source *AppSrc `json:"source,omitempty"`
+
+	// Sources are the main applications of the environment, e.g., the microservices of a product
+	// +optional
+	Sources []AppSrc `json:"sources,omitempty"`
 
 	// Dependencies are the dependencies required for the main application
 	Dependencies []DependencySrc `json:"dependencies,omitempty"`
@@ -41,11 +59,521 @@ type EnvironmentSpec struct {
 	// ClusterName is the name of the cluster to provision in the cloud provider
 	ClusterName string `json:"clusterName,omitempty"`
 
+	// Provider is the cloud provider used to provision the cluster.
+	// kind and k3d provision a local cluster instead, for offline development, vcluster
+	// deploys a virtual cluster to the cluster the controller runs in through argocd.
+	// Defaults to gke when not specified.
+	// +kubebuilder:validation:Enum=gke;eks;aks;kind;k3d;vcluster
+	Provider ClusterProviderType `json:"provider,omitempty"`
+
+	// Isolation decides whether the environment gets a dedicated cluster (default),
+	// a namespace in the cluster the controller runs in or a virtual cluster inside such a namespace.
+	// `provider` and `clusterClassLabel` are ignored in the namespace and vcluster modes.
+	// +kubebuilder:validation:Enum=namespace;vcluster;cluster
+	Isolation IsolationMode `json:"isolation,omitempty"`
+
+	// NamespaceIsolation configures the namespace created in the namespace and vcluster isolation modes
+	NamespaceIsolation *NamespaceIsolationSpec `json:"namespaceIsolation,omitempty"`
+
+	// NodePools are the node pools of the cluster, only supported by the gke provider.
+	// Defaults to a single pool of 2 nodes named after the cluster.
+	// +optional
+	NodePools []NodePoolSpec `json:"nodePools,omitempty"`
+
 	// TTL (Time to Live) is the time duration for which the cluster should live.
 	// Once the TTL is exceeded, the cluster is automatically deleted.
 	// Optional parameter with no default value.
 	// +kubebuilder:validation:Pattern=^(([0-9]+)m|([0-9]+)h|([0-9]+)d|([0-9]+)y)$
 	TTL string `json:"ttl,omitempty"`
+
+	// SuspendTTL pauses the TTL, the environment isn't deleted while it's set.
+	// The time the TTL was suspended for is added to the expiry once it's unset.
+	// +optional
+	SuspendTTL bool `json:"suspendTTL,omitempty"`
+
+	// Owner of the environment, e.g., the email address of the developer who created it.
+	// It's notified by email about the TTL when `notify.email` has no recipients.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// Notify configures the notifications sent before the TTL expires and when it's exceeded
+	// +optional
+	Notify *NotifySpec `json:"notify,omitempty"`
+
+	// Schedule puts the environment to sleep outside its active windows, e.g., overnight and on weekends
+	// +optional
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+
+	// Idle hibernates or deletes the environment once it hasn't been used for a while
+	// +optional
+	Idle *IdlePolicy `json:"idle,omitempty"`
+
+	// Budget bounds the cost the environment may accrue according to the price table of the controller.
+	// Only gke clusters are priced, so it requires the gke provider and cluster isolation.
+	// +optional
+	Budget *BudgetSpec `json:"budget,omitempty"`
+}
+
+// BudgetAction is what happens to an environment that exhausted its budget
+type BudgetAction string
+
+const (
+	// BudgetActionHibernate puts the environment to sleep until its budget is raised
+	BudgetActionHibernate BudgetAction = "Hibernate"
+	// BudgetActionDelete deletes the environment
+	BudgetActionDelete BudgetAction = "Delete"
+)
+
+// BudgetSpec is the cost an environment may accrue, in the currency of the price table
+type BudgetSpec struct {
+	// Limit is the cost the environment may accrue (e.g., "50" or "12.50")
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+	Limit string `json:"limit"`
+
+	// WarnAtPercent is the share of the limit a warning is sent at, defaults to 80
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	WarnAtPercent int32 `json:"warnAtPercent,omitempty"`
+
+	// Action is taken once the budget is exhausted, defaults to Hibernate
+	// +kubebuilder:validation:Enum=Hibernate;Delete
+	// +optional
+	Action BudgetAction `json:"action,omitempty"`
+}
+
+// IdleAction is what happens to an idle environment
+type IdleAction string
+
+const (
+	// IdleActionHibernate puts the environment to sleep like outside the windows of its schedule
+	IdleActionHibernate IdleAction = "Hibernate"
+	// IdleActionDelete deletes the environment
+	IdleActionDelete IdleAction = "Delete"
+)
+
+// IdlePolicy decides when an environment is idle. The environment is active when argocd synced or
+// deployed one of its applications, the last-activity annotation was updated, the prometheus query
+// returned a value above zero or its schedule woke it up within the last `after`.
+// A hibernated environment wakes up once one of them reports activity again.
+type IdlePolicy struct {
+	// After is how long the environment has to be unused to be idle (e.g., 4h)
+	// +kubebuilder:validation:Required
+	After metav1.Duration `json:"after"`
+
+	// Action is taken once the environment is idle, defaults to Hibernate
+	// +kubebuilder:validation:Enum=Hibernate;Delete
+	// +optional
+	Action IdleAction `json:"action,omitempty"`
+
+	// Prometheus reports activity while its query returns a value above zero,
+	// e.g., the request rate of the ingress of the environment
+	// +optional
+	Prometheus *PrometheusSignal `json:"prometheus,omitempty"`
+}
+
+// PrometheusSignal is a prometheus query reporting the activity of an environment
+type PrometheusSignal struct {
+	// URL of the prometheus server (e.g., http://prometheus.monitoring:9090)
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+
+	// Query is an instant query, the environment is active while one of its samples is above zero
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Query string `json:"query"`
+}
+
+// ScheduleSpec defines when an environment is awake. The environment sleeps outside all of its windows:
+// the node pools of gke clusters are deleted (and recreated on wake), other environments have the
+// auto-sync of their argocd applications suspended and their deployments and statefulsets scaled to zero.
+type ScheduleSpec struct {
+	// Timezone the windows are evaluated in (e.g., Europe/Berlin), defaults to UTC
+	// +optional
+	Timezone string `json:"timezone,omitempty"`
+
+	// Windows are the times the environment is awake in
+	// +kubebuilder:validation:MinItems=1
+	Windows []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow is an active window of an environment between a wake and a sleep cron expression,
+// e.g., wake `0 8 * * 1-5` and sleep `0 19 * * 1-5` for office hours.
+// Windows are expected to repeat at least weekly.
+type ScheduleWindow struct {
+	// Wake is the cron expression (minute hour day-of-month month day-of-week) the window starts at
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Wake string `json:"wake"`
+
+	// Sleep is the cron expression the window ends at
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Sleep string `json:"sleep"`
+}
+
+// NotifySpec configures where the TTL notifications of an environment are sent
+type NotifySpec struct {
+	// BeforeExpiry are the times before the TTL expires a warning is sent (e.g., 1h, 10m).
+	// Defaults to the thresholds the controller is started with.
+	// +optional
+	BeforeExpiry []metav1.Duration `json:"beforeExpiry,omitempty"`
+
+	// Webhook receives the notifications as JSON
+	// +optional
+	Webhook *WebhookNotification `json:"webhook,omitempty"`
+
+	// Slack receives the notifications through an incoming webhook (or anything accepting its payload)
+	// +optional
+	Slack *WebhookNotification `json:"slack,omitempty"`
+
+	// Email sends the notifications through the SMTP server the controller is configured with
+	// +optional
+	Email *EmailNotification `json:"email,omitempty"`
+}
+
+// WebhookNotification is the URL notifications are posted to. Exactly one of url and urlFrom must be set.
+type WebhookNotification struct {
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// URLFrom reads the URL from a ConfigMap or a Secret, webhook URLs often contain a token
+	// +optional
+	URLFrom *ValueSource `json:"urlFrom,omitempty"`
+}
+
+// EmailNotification lists the recipients of notification emails
+type EmailNotification struct {
+	// To defaults to the owner of the environment
+	// +optional
+	To []string `json:"to,omitempty"`
+}
+
+// TemplateRef references the EnvironmentTemplate an environment is rendered from
+type TemplateRef struct {
+	// Name of the EnvironmentTemplate
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Parameters are the values of the parameters declared by the template
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// ClusterProviderType is the cloud provider used to provision the cluster of an environment
+type ClusterProviderType string
+
+const (
+	// ProviderGKE provisions the cluster on Google Kubernetes Engine
+	ProviderGKE ClusterProviderType = "gke"
+	// ProviderEKS provisions the cluster on Amazon Elastic Kubernetes Service
+	ProviderEKS ClusterProviderType = "eks"
+	// ProviderAKS provisions the cluster on Azure Kubernetes Service
+	ProviderAKS ClusterProviderType = "aks"
+	// ProviderKind provisions a local kind cluster
+	ProviderKind ClusterProviderType = "kind"
+	// ProviderK3d provisions a local k3d cluster
+	ProviderK3d ClusterProviderType = "k3d"
+	// ProviderVCluster provisions a virtual cluster inside the cluster the controller runs in
+	ProviderVCluster ClusterProviderType = "vcluster"
+)
+
+// ClusterClassGroupVersionKind returns the kind of the crossplane cluster class used by the provider.
+// It returns false for providers that don't use a cluster class.
+func (p ClusterProviderType) ClusterClassGroupVersionKind() (schema.GroupVersionKind, bool) {
+	switch p {
+	case "", ProviderGKE:
+		return schema.GroupVersionKind{Group: "container.gcp.crossplane.io", Version: "v1beta1", Kind: "GKEClusterClass"}, true
+	case ProviderEKS:
+		return schema.GroupVersionKind{Group: "eks.aws.crossplane.io", Version: "v1beta1", Kind: "EKSClusterClass"}, true
+	case ProviderAKS:
+		return schema.GroupVersionKind{Group: "compute.azure.crossplane.io", Version: "v1alpha3", Kind: "AKSClusterClass"}, true
+	}
+
+	return schema.GroupVersionKind{}, false
+}
+
+// IsolationMode decides how an environment is isolated from other environments
+type IsolationMode string
+
+const (
+	// IsolationCluster provisions a dedicated cluster for the environment
+	IsolationCluster IsolationMode = "cluster"
+	// IsolationNamespace deploys the environment to its own namespace in the host cluster
+	IsolationNamespace IsolationMode = "namespace"
+	// IsolationVCluster deploys the environment to a virtual cluster running in its own namespace in the host cluster
+	IsolationVCluster IsolationMode = "vcluster"
+)
+
+// NamespaceIsolationSpec configures the namespace of environments without a dedicated cluster
+type NamespaceIsolationSpec struct {
+	// ResourceQuota is the hard limit of the namespace's resource quota.
+	// Defaults to a limit of 50 pods.
+	ResourceQuota corev1.ResourceList `json:"resourceQuota,omitempty"`
+
+	// Members are granted the `edit` cluster role in the namespace
+	Members []rbacv1.Subject `json:"members,omitempty"`
+}
+
+// HelmSpec configures how the helm chart of an application is rendered
+type HelmSpec struct {
+	// Values is a block of helm values. It takes precedence over ValuesFrom.
+	// +optional
+	Values string `json:"values,omitempty"`
+
+	// ValuesFrom are ConfigMap or Secret keys holding blocks of helm values.
+	// They are resolved when the argocd application is reconciled and merged in order.
+	// Note: the resolved values end up in the argocd application.
+	// +optional
+	ValuesFrom []ValueSource `json:"valuesFrom,omitempty"`
+
+	// ValueFiles are helm value files in the repository
+	// +optional
+	ValueFiles []string `json:"valueFiles,omitempty"`
+
+	// Parameters override single helm values
+	// +optional
+	Parameters []HelmParameter `json:"parameters,omitempty"`
+}
+
+// HelmParameter overrides a single helm value
+type HelmParameter struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Value of the parameter, ignored when ValueFrom is set
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// ValueFrom reads the value of the parameter from a ConfigMap or a Secret key
+	// +optional
+	ValueFrom *ValueSource `json:"valueFrom,omitempty"`
+
+	// ForceString makes helm interpret booleans and numbers as strings
+	// +optional
+	ForceString bool `json:"forceString,omitempty"`
+}
+
+// ValueSource references a value in a ConfigMap or a Secret. Exactly one of them must be set.
+type ValueSource struct {
+	// +optional
+	ConfigMapKeyRef *ValueKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// +optional
+	SecretKeyRef *ValueKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// ValueKeySelector selects a key of a ConfigMap or a Secret.
+// The namespace is required because environments are cluster scoped.
+type ValueKeySelector struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// Optional makes a missing ConfigMap, Secret or key resolve to an empty value
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+}
+
+// KustomizeSpec configures how the kustomization of an application is rendered
+type KustomizeSpec struct {
+	// NamePrefix is prepended to the names of the resources
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
+	// NameSuffix is appended to the names of the resources
+	// +optional
+	NameSuffix string `json:"nameSuffix,omitempty"`
+
+	// Images override images of the kustomization, in the format of `kustomize edit set image`
+	// (e.g., `myapp=myregistry/myapp:pr-42` or `myapp:pr-42`)
+	// +optional
+	Images []string `json:"images,omitempty"`
+
+	// CommonLabels are added to all resources
+	// +optional
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+}
+
+// ImageOverride replaces the tag or digest of an image
+type ImageOverride struct {
+	// Name is the image without tag or digest as referenced by the manifests (e.g., myregistry/myapp)
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Tag replaces the tag of the image. Exactly one of tag and digest must be set.
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Digest replaces the image by its digest (e.g., sha256:...)
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// HelmParameters are the helm values the override is written to
+	// +optional
+	HelmParameters *ImageHelmParameters `json:"helmParameters,omitempty"`
+}
+
+// ImageHelmParameters are the helm values an image override is written to
+type ImageHelmParameters struct {
+	// Repository is the helm value the image name is written to, defaults to image.repository
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Tag is the helm value the tag is written to, defaults to image.tag
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Digest is the helm value the digest is written to, it is required for digests
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// Reference returns the image reference the override resolves to
+func (o ImageOverride) Reference() string {
+	if o.Digest != "" {
+		return fmt.Sprintf("%s@%s", o.Name, o.Digest)
+	}
+
+	return fmt.Sprintf("%s:%s", o.Name, o.Tag)
+}
+
+// DirectorySpec configures how a directory of an application is rendered
+type DirectorySpec struct {
+	// Recurse includes the manifests of subdirectories
+	// +optional
+	Recurse bool `json:"recurse,omitempty"`
+
+	// Jsonnet configures how jsonnet files of the directory are evaluated
+	// +optional
+	Jsonnet *JsonnetSpec `json:"jsonnet,omitempty"`
+}
+
+// JsonnetSpec configures how jsonnet files are evaluated
+type JsonnetSpec struct {
+	// ExtVars are jsonnet external variables
+	// +optional
+	ExtVars []JsonnetVar `json:"extVars,omitempty"`
+
+	// TLAs are jsonnet top level arguments
+	// +optional
+	TLAs []JsonnetVar `json:"tlas,omitempty"`
+}
+
+// JsonnetVar is a jsonnet variable
+type JsonnetVar struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	Value string `json:"value"`
+
+	// Code evaluates the value as jsonnet code instead of a string
+	// +optional
+	Code bool `json:"code,omitempty"`
+}
+
+// NodePoolSpec describes a node pool of the cluster.
+// Changing the node configuration (e.g., machine type, disk or taints) recreates the node pool
+// because GKE can't update it in place, autoscaling is updated in place.
+type NodePoolSpec struct {
+	// Name of the node pool, the crossplane NodePool is named `<clusterName>-<name>`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=40
+	// +kubebuilder:validation:Pattern=^[a-z]([-a-z0-9]*[a-z0-9])?$
+	Name string `json:"name"`
+
+	// ProfileRef is the name of a NodePoolProfile whose node configuration is used as the default.
+	// Fields set on the node pool override the profile, labels are merged.
+	// +optional
+	ProfileRef string `json:"profileRef,omitempty"`
+
+	NodeConfig `json:",inline"`
+
+	// NodeCount is the number of nodes of the pool, defaults to 2. Pools without autoscaling are
+	// recreated when it changes, with autoscaling it's only the number of nodes the pool is created with.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	NodeCount *int64 `json:"nodeCount,omitempty"`
+
+	// Autoscaling lets GKE resize the pool between MinNodes and MaxNodes
+	// +optional
+	Autoscaling bool `json:"autoscaling,omitempty"`
+
+	// MinNodes is the minimum number of nodes when autoscaling
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinNodes *int64 `json:"minNodes,omitempty"`
+
+	// MaxNodes is the maximum number of nodes when autoscaling
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxNodes *int64 `json:"maxNodes,omitempty"`
+}
+
+// NodeConfig is the hardware and configuration of the nodes of a node pool.
+// Fields that aren't set use the GKE defaults.
+type NodeConfig struct {
+	// MachineType of the nodes (e.g., e2-standard-4)
+	// +optional
+	MachineType string `json:"machineType,omitempty"`
+
+	// DiskSizeGb is the size of the boot disk of the nodes
+	// +kubebuilder:validation:Minimum=10
+	// +optional
+	DiskSizeGb *int64 `json:"diskSizeGb,omitempty"`
+
+	// DiskType is the type of the boot disk of the nodes
+	// +kubebuilder:validation:Enum=pd-standard;pd-ssd;pd-balanced
+	// +optional
+	DiskType string `json:"diskType,omitempty"`
+
+	// Preemptible nodes are cheaper but can be stopped at any time
+	// +optional
+	Preemptible *bool `json:"preemptible,omitempty"`
+
+	// Labels are added to the nodes
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Taints are added to the nodes
+	// +optional
+	Taints []NodeTaint `json:"taints,omitempty"`
+
+	// OAuthScopes are the scopes of the service account of the nodes
+	// (e.g., https://www.googleapis.com/auth/cloud-platform)
+	// +optional
+	OAuthScopes []string `json:"oauthScopes,omitempty"`
+
+	// ServiceAccount is the email of the google service account used by the nodes
+	// +optional
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+}
+
+// NodeTaint is a taint added to the nodes of a node pool
+type NodeTaint struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// +kubebuilder:validation:Enum=NoSchedule;PreferNoSchedule;NoExecute
+	Effect corev1.TaintEffect `json:"effect"`
 }
 
 // AppSrc defines fields related to the source repository/location of the application
@@ -63,6 +591,11 @@ type AppSrc struct {
 	// +kubebuilder:validation:MinLength=1
 	Path string `json:"path"`
 
+	// DependsOn are the names of the dependencies that have to be synced and healthy before
+	// the application is deployed. Defaults to all dependencies.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MinLength=1
 	Revision string `json:"revision"`
@@ -73,6 +606,28 @@ type AppSrc struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MinLength=1
 	RepoURL string `json:"repoURL"`
+
+	// ReleaseName is the helm release name, defaults to the name of the application for charts and helm sources
+	// +optional
+	ReleaseName string `json:"releaseName,omitempty"`
+
+	// Helm configures how the helm chart is rendered
+	// +optional
+	Helm *HelmSpec `json:"helm,omitempty"`
+
+	// Kustomize configures how a kustomization is rendered
+	// +optional
+	Kustomize *KustomizeSpec `json:"kustomize,omitempty"`
+
+	// Directory configures how a directory of plain manifests or jsonnet is rendered
+	// +optional
+	Directory *DirectorySpec `json:"directory,omitempty"`
+
+	// ImageOverrides replace the tag or digest of images of the source, e.g., to deploy the image of a pull request.
+	// They're applied as helm parameters when the source is a helm chart (chartName or helm is set)
+	// and as kustomize images otherwise.
+	// +optional
+	ImageOverrides []ImageOverride `json:"imageOverrides,omitempty"`
 }
 
 // DependencySrc defines fields related to the source repository/location of the application
@@ -84,6 +639,11 @@ type DependencySrc struct {
 
 	Namespace string `json:"namespace,omitempty"`
 
+	// DependsOn are the names of the dependencies that have to be synced and healthy before
+	// the dependency is deployed
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MinLength=1
 	Revision string `json:"revision"`
@@ -94,24 +654,233 @@ type DependencySrc struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:MinLength=1
 	RepoURL string `json:"repoURL"`
+
+	// ReleaseName is the helm release name, defaults to the name of the application for charts and helm sources
+	// +optional
+	ReleaseName string `json:"releaseName,omitempty"`
+
+	// Helm configures how the helm chart is rendered
+	// +optional
+	Helm *HelmSpec `json:"helm,omitempty"`
+
+	// Kustomize configures how a kustomization is rendered
+	// +optional
+	Kustomize *KustomizeSpec `json:"kustomize,omitempty"`
+
+	// Directory configures how a directory of plain manifests or jsonnet is rendered
+	// +optional
+	Directory *DirectorySpec `json:"directory,omitempty"`
 }
 
+// EnvironmentPhase is the step of the environment's lifecycle the controller is in
+// +kubebuilder:validation:Enum=Pending;ProvisioningCluster;ProvisioningNodePool;DeployingDependencies;DeployingSource;Ready;Expiring;Sleeping;Deleting;Failed
+type EnvironmentPhase string
+
+const (
+	// PhasePending means the cluster class of the environment hasn't been resolved yet
+	PhasePending EnvironmentPhase = "Pending"
+	// PhaseProvisioningCluster means the environment is waiting for its cluster
+	PhaseProvisioningCluster EnvironmentPhase = "ProvisioningCluster"
+	// PhaseProvisioningNodePool means the environment is waiting for the node pools of its cluster
+	PhaseProvisioningNodePool EnvironmentPhase = "ProvisioningNodePool"
+	// PhaseDeployingDependencies means the environment is waiting for its dependencies to be synced and healthy
+	PhaseDeployingDependencies EnvironmentPhase = "DeployingDependencies"
+	// PhaseDeployingSource means the environment is waiting for its source to be synced and healthy
+	PhaseDeployingSource EnvironmentPhase = "DeployingSource"
+	// PhaseReady means the cluster and all applications are ready
+	PhaseReady EnvironmentPhase = "Ready"
+	// PhaseExpiring means the environment is ready but its TTL is about to be exceeded
+	PhaseExpiring EnvironmentPhase = "Expiring"
+	// PhaseSleeping means the environment is scaled down outside the active windows of its schedule
+	PhaseSleeping EnvironmentPhase = "Sleeping"
+	// PhaseDeleting means the environment is being deleted
+	PhaseDeleting EnvironmentPhase = "Deleting"
+	// PhaseFailed means the environment can't make progress until its spec is fixed
+	PhaseFailed EnvironmentPhase = "Failed"
+)
+
 // EnvironmentStatus defines the observed state of Environment
 type EnvironmentStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	ClusterStatus     metav1.Status `json:"clusterStatus,omitempty"`
-	ApplicationStatus metav1.Status `json:"applicationStatus,omitempty"`
-	DependencyStatus  metav1.Status `json:"dependencyStatus,omitempty"`
+
+	// Phase is the step of the environment's lifecycle the controller is in
+	// +optional
+	Phase EnvironmentPhase `json:"phase,omitempty"`
+
+	// Conditions describe what the environment is waiting for
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 
 	Ready             bool         `json:"ready,omitempty"`
 	TTLStartTimestamp *metav1.Time `json:"ttlStartTimestamp,omitempty"`
+
+	// TTLSuspendedTimestamp is when the TTL was suspended with `spec.suspendTTL`
+	// +optional
+	TTLSuspendedTimestamp *metav1.Time `json:"ttlSuspendedTimestamp,omitempty"`
+
+	// TTLExtension is the time the TTL was extended by with the extend-ttl annotation
+	// +optional
+	TTLExtension *metav1.Duration `json:"ttlExtension,omitempty"`
+
+	// ExpiresAt is when the environment is deleted because of its TTL
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// LastTTLWarning is the last warning sent before the TTL expires
+	// +optional
+	LastTTLWarning *TTLWarning `json:"lastTTLWarning,omitempty"`
+
+	// LastActivity is the last time the environment was used according to its idle policy
+	// +optional
+	LastActivity *metav1.Time `json:"lastActivity,omitempty"`
+
+	// Applications are the argocd applications deployed for the source and the dependencies
+	Applications []ApplicationRef `json:"applications,omitempty"`
+
+	// Sources is the observed state of the argocd application of each source
+	Sources []ApplicationStatus `json:"sources,omitempty"`
+
+	// Dependencies is the observed state of the argocd application of each dependency
+	Dependencies []ApplicationStatus `json:"dependencies,omitempty"`
+
+	// Images are the images deployed by the source applications as reported by argocd
+	Images []string `json:"images,omitempty"`
+
+	// Template is the EnvironmentTemplate the spec was rendered from
+	// +optional
+	Template *TemplateStatus `json:"template,omitempty"`
+
+	// Teardown is the progress of the deletion of the environment
+	// +optional
+	Teardown *TeardownStatus `json:"teardown,omitempty"`
+
+	// Cost is the estimated cost of the environment according to the price table of the controller
+	// +optional
+	Cost *CostStatus `json:"cost,omitempty"`
+}
+
+// CostStatus is the estimated cost of an environment, in the currency of the price table
+type CostStatus struct {
+	// HourlyEstimate is the cost of the node pools per hour, autoscaling pools are counted with their maximum size
+	HourlyEstimate string `json:"hourlyEstimate"`
+
+	// Accrued is the cost accrued since the environment was first priced, nothing accrues while the node pools of a sleeping environment are deleted
+	Accrued string `json:"accrued"`
+
+	// LastAccrued is when Accrued was last updated
+	LastAccrued metav1.Time `json:"lastAccrued"`
+
+	// Unpriced are the machine types missing from the price table, they're left out of the estimate
+	// +optional
+	Unpriced []string `json:"unpriced,omitempty"`
+
+	// BudgetWarning is the budget limit a warning was sent for, a new limit warns again
+	// +optional
+	BudgetWarning string `json:"budgetWarning,omitempty"`
+}
+
+// TeardownStep is the step of the ordered deletion of an environment
+// +kubebuilder:validation:Enum=DeletingApplications;DeletingNodePools;DeletingClusterClaim;DeletingCluster
+type TeardownStep string
+
+const (
+	// TeardownDeletingApplications means the argocd applications and the resources they deployed are being deleted
+	TeardownDeletingApplications TeardownStep = "DeletingApplications"
+	// TeardownDeletingNodePools means the node pools of the cluster are being deleted
+	TeardownDeletingNodePools TeardownStep = "DeletingNodePools"
+	// TeardownDeletingClusterClaim means the KubernetesCluster claim is being deleted
+	TeardownDeletingClusterClaim TeardownStep = "DeletingClusterClaim"
+	// TeardownDeletingCluster means a cluster that isn't a kubernetes object (e.g., kind) is being deleted
+	TeardownDeletingCluster TeardownStep = "DeletingCluster"
+)
+
+// TeardownStatus records the progress of the deletion of an environment
+type TeardownStatus struct {
+	// Step is the step the deletion waits for
+	Step TeardownStep `json:"step"`
+
+	// Waiting are the objects of the step that aren't gone yet
+	// +optional
+	Waiting []string `json:"waiting,omitempty"`
+}
+
+// TTLWarning records a warning sent before the TTL of an environment expires
+type TTLWarning struct {
+	// ExpiresAt is the expiry the warning was sent for, a new expiry (e.g., an extension) warns again
+	ExpiresAt metav1.Time `json:"expiresAt"`
+
+	// BeforeExpiry is the threshold the warning was sent for
+	BeforeExpiry metav1.Duration `json:"beforeExpiry"`
+}
+
+// TemplateStatus records the EnvironmentTemplate an environment was rendered from
+type TemplateStatus struct {
+	// Name of the EnvironmentTemplate
+	Name string `json:"name"`
+
+	// Generation of the EnvironmentTemplate the spec was rendered from
+	Generation int64 `json:"generation"`
+}
+
+// ApplicationStatus is the observed state of the argocd application deployed for an application of the spec
+type ApplicationStatus struct {
+	// Name is the name of the application in the spec
+	Name string `json:"name"`
+
+	// ApplicationName is the name of the argocd application
+	ApplicationName string `json:"applicationName,omitempty"`
+
+	// SyncStatus is the argocd sync status (Synced, OutOfSync, Unknown)
+	SyncStatus string `json:"syncStatus,omitempty"`
+
+	// HealthStatus is the argocd health status (Healthy, Progressing, Degraded, Suspended, Missing, Unknown)
+	HealthStatus string `json:"healthStatus,omitempty"`
+
+	// Revision is the revision the application is synced to
+	Revision string `json:"revision,omitempty"`
+
+	// Message is the last error reported for the application
+	Message string `json:"message,omitempty"`
+
+	// Ready is true when the application is synced and healthy
+	Ready bool `json:"ready"`
+}
+
+// ApplicationRef maps an application of the spec to the argocd application deployed for it
+type ApplicationRef struct {
+	// Name is the name of the application in the spec
+	Name string `json:"name"`
+
+	// ApplicationName is the name of the argocd application
+	ApplicationName string `json:"applicationName"`
+
+	// LastPatchTime is the last time the argocd application was patched because
+	// it differed from the environment spec, either because the spec changed or
+	// because the application was changed outside the controller
+	// +optional
+	LastPatchTime *metav1.Time `json:"lastPatchTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="Ready",type=boolean,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.status.conditions[?(@.type=="ClusterProvisioned")].status`
+// +kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.status.conditions[?(@.type=="SourceSynced")].status`
+// +kubebuilder:printcolumn:name="Dependencies",type=string,JSONPath=`.status.conditions[?(@.type=="DependenciesHealthy")].status`
+// +kubebuilder:printcolumn:name="Expires At",type=date,JSONPath=`.status.expiresAt`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`,priority=1
+// +kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.status.template.name`,priority=1
+// +kubebuilder:printcolumn:name="Template Up-to-date",type=string,JSONPath=`.status.conditions[?(@.type=="TemplateUpToDate")].status`,priority=1
+// +kubebuilder:printcolumn:name="Cost",type=string,JSONPath=`.status.cost.accrued`,priority=1
+// +kubebuilder:printcolumn:name="Teardown",type=string,JSONPath=`.status.teardown.step`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // Environment is the Schema for the environments API
 type Environment struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -121,6 +890,42 @@ type Environment struct {
 	Status EnvironmentStatus `json:"status,omitempty"`
 }
 
+// SourceApplications returns the source applications of the environment.
+// The deprecated source is converted into the first of them, so existing environments keep working.
+func (in *EnvironmentSpec) SourceApplications() []*AppSrc {
+	sources := []*AppSrc{}
+	if in.Source != nil {
+		sources = append(sources, in.Source)
+	}
+	for i := range in.Sources {
+		sources = append(sources, &in.Sources[i])
+	}
+
+	return sources
+}
+
+// ApplicationName returns the name of the argocd application deployed for an application of the environment.
+// Names are scoped with the environment name and a hash of its UID, so environments deploying the same
+// application don't collide in the shared argocd namespace, even when `<env>-<name>` is ambiguous
+// (environment `a` with application `b-c` and environment `a-b` with application `c`).
+// Argocd labels the resources of an application with its name, so names longer than a label value are
+// truncated and hashed with the application name instead.
+func (in *Environment) ApplicationName(name string) string {
+	uid := sha256.Sum256([]byte(in.UID))
+	applicationName := fmt.Sprintf("%s-%s-%s", in.Name, name, hex.EncodeToString(uid[:])[:8])
+	if len(applicationName) <= maxApplicationNameLength {
+		return applicationName
+	}
+
+	// applications whose names share the truncated prefix get different hashes
+	hash := sha256.Sum256([]byte(string(in.UID) + "/" + name))
+	prefix := strings.TrimRight(fmt.Sprintf("%s-%s", in.Name, name)[:maxApplicationNameLength-9], "-")
+	return fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(hash[:])[:8])
+}
+
+// maxApplicationNameLength is the maximum length of a label value
+const maxApplicationNameLength = 63
+
 // +kubebuilder:object:root=true
 
 // EnvironmentList contains a list of Environment
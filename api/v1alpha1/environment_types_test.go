@@ -0,0 +1,42 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplicationName(t *testing.T) {
+	env := &Environment{ObjectMeta: metav1.ObjectMeta{Name: "feature", UID: "feature-uid"}}
+	if name := env.ApplicationName("mysql"); !strings.HasPrefix(name, "feature-mysql-") || len(name) != len("feature-mysql-")+8 {
+		t.Errorf("got application name %s, want feature-mysql-<hash>", name)
+	}
+
+	env.Name = strings.Repeat("long-environment-name-", 3)
+	first, second := env.ApplicationName("service-a"), env.ApplicationName("service-b")
+	for _, name := range []string{first, second} {
+		if len(name) > maxApplicationNameLength {
+			t.Errorf("got application name %s of %d characters, want at most %d", name, len(name), maxApplicationNameLength)
+		}
+	}
+	if first == second {
+		t.Errorf("applications sharing the truncated prefix got the same name %s", first)
+	}
+}
@@ -0,0 +1,605 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var environmentlog = logf.Log.WithName("environment-resource")
+
+// environmentClient is used by the webhooks to look up other environments and cluster classes
+var environmentClient client.Client
+
+func (r *Environment) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	environmentClient = mgr.GetClient()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-dev-vadasambar-github-io-v1alpha1-environment,mutating=true,failurePolicy=fail,groups=dev.vadasambar.github.io,resources=environments,verbs=create;update,versions=v1alpha1,name=menvironment.kb.io
+
+var _ webhook.Defaulter = &Environment{}
+
+// DefaultNamespace is the namespace applications are deployed to when none is specified
+const DefaultNamespace = "default"
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Environment) Default() {
+	environmentlog.Info("default", "name", r.Name)
+
+	// the cluster name is only defaulted on creation (the object has no creation timestamp yet),
+	// renaming the cluster of an existing environment would orphan it.
+	// The template may name the cluster, the controller defaults it once the template is rendered.
+	if r.CreationTimestamp.IsZero() && r.Spec.ClusterName == "" && r.Spec.TemplateRef == nil {
+		r.Spec.ClusterName = r.Name
+	}
+
+	// application names aren't prefixed with the environment name here, the controller scopes the names
+	// of the argocd applications with ApplicationName. Prefixing the spec would name the applications twice,
+	// break the dependsOn references written against the spec names and rename the helm releases.
+
+	sources := r.Spec.SourceApplications()
+	for _, source := range sources {
+		if source.Namespace == "" {
+			source.Namespace = DefaultNamespace
+		}
+	}
+
+	dependencyNamespace := DefaultNamespace
+	if len(sources) > 0 {
+		dependencyNamespace = sources[0].Namespace
+	}
+	for i := range r.Spec.Dependencies {
+		if r.Spec.Dependencies[i].Namespace == "" {
+			r.Spec.Dependencies[i].Namespace = dependencyNamespace
+		}
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-dev-vadasambar-github-io-v1alpha1-environment,mutating=false,failurePolicy=fail,groups=dev.vadasambar.github.io,resources=environments,versions=v1alpha1,name=venvironment.kb.io
+
+var _ webhook.Validator = &Environment{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Environment) ValidateCreate() error {
+	environmentlog.Info("validate create", "name", r.Name)
+
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, r.validateTemplateRef()...)
+	allErrs = append(allErrs, r.validateSources()...)
+	allErrs = append(allErrs, r.validateAppNames()...)
+	allErrs = append(allErrs, r.validateDependsOn()...)
+	allErrs = append(allErrs, r.validateHelm()...)
+	allErrs = append(allErrs, r.validateTools()...)
+	allErrs = append(allErrs, r.validateImageOverrides()...)
+	allErrs = append(allErrs, r.validateNodePools(true)...)
+	allErrs = append(allErrs, r.validateNotify()...)
+	allErrs = append(allErrs, r.validateSchedule()...)
+	allErrs = append(allErrs, r.validateIdle()...)
+	allErrs = append(allErrs, r.validateBudget()...)
+	allErrs = append(allErrs, r.validateClusterName()...)
+	allErrs = append(allErrs, r.validateClusterClass()...)
+
+	return r.toInvalidError(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Environment) ValidateUpdate(old runtime.Object) error {
+	environmentlog.Info("validate update", "name", r.Name)
+
+	oldEnv := old.(*Environment)
+	// the controller removes its finalizers from deleted environments, they mustn't be blocked
+	if !r.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, r.validateSources()...)
+	allErrs = append(allErrs, r.validateAppNames()...)
+	allErrs = append(allErrs, r.validateDependsOn()...)
+	allErrs = append(allErrs, r.validateHelm()...)
+	allErrs = append(allErrs, r.validateTools()...)
+	allErrs = append(allErrs, r.validateImageOverrides()...)
+	allErrs = append(allErrs, r.validateNodePools(!equality.Semantic.DeepEqual(r.Spec.NodePools, oldEnv.Spec.NodePools))...)
+	allErrs = append(allErrs, r.validateNotify()...)
+	allErrs = append(allErrs, r.validateSchedule()...)
+	allErrs = append(allErrs, r.validateIdle()...)
+	allErrs = append(allErrs, r.validateBudget()...)
+	allErrs = append(allErrs, r.validateImmutableFields(oldEnv)...)
+	// the references and the cluster name are checked when they change, a class, a template or a node pool profile
+	// deleted later or a failing list mustn't block updates like the removal of finalizers
+	if r.Spec.ClusterName != oldEnv.Spec.ClusterName {
+		allErrs = append(allErrs, r.validateClusterName()...)
+	}
+	if r.Spec.ClusterClassLabel != oldEnv.Spec.ClusterClassLabel || r.Spec.Provider != oldEnv.Spec.Provider ||
+		r.Spec.Isolation != oldEnv.Spec.Isolation {
+		allErrs = append(allErrs, r.validateClusterClass()...)
+	}
+	if !equality.Semantic.DeepEqual(r.Spec.TemplateRef, oldEnv.Spec.TemplateRef) {
+		allErrs = append(allErrs, r.validateTemplateRef()...)
+	}
+
+	return r.toInvalidError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Environment) ValidateDelete() error {
+	return nil
+}
+
+func (r *Environment) toInvalidError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return kerrors.NewInvalid(GroupVersion.WithKind("Environment").GroupKind(), r.Name, allErrs)
+}
+
+// sourcePaths returns the field path of each source returned by SourceApplications
+func (in *EnvironmentSpec) sourcePaths() []*field.Path {
+	paths := []*field.Path{}
+	if in.Source != nil {
+		paths = append(paths, field.NewPath("spec").Child("source"))
+	}
+	for i := range in.Sources {
+		paths = append(paths, field.NewPath("spec").Child("sources").Index(i))
+	}
+
+	return paths
+}
+
+// validateSources requires at least one source
+func (r *Environment) validateSources() field.ErrorList {
+	if len(r.Spec.SourceApplications()) == 0 && !r.pendingTemplate() {
+		return field.ErrorList{field.Required(field.NewPath("spec").Child("sources"), "at least one of source and sources must be set")}
+	}
+
+	return nil
+}
+
+// validateAppNames rejects duplicate application names, they become argocd application names in a shared namespace
+func (r *Environment) validateAppNames() field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	sourcePaths := r.Spec.sourcePaths()
+	for i, source := range r.Spec.SourceApplications() {
+		if names[source.Name] {
+			allErrs = append(allErrs, field.Duplicate(sourcePaths[i].Child("name"), source.Name))
+			continue
+		}
+		names[source.Name] = true
+	}
+	for i, dependency := range r.Spec.Dependencies {
+		if names[dependency.Name] {
+			allErrs = append(allErrs, field.Duplicate(field.NewPath("spec").Child("dependencies").Index(i).Child("name"), dependency.Name))
+			continue
+		}
+		names[dependency.Name] = true
+	}
+
+	return allErrs
+}
+
+// validateDependsOn rejects references to unknown dependencies and cycles between dependencies
+func (r *Environment) validateDependsOn() field.ErrorList {
+	if _, err := r.DependencyOrder(); err != nil {
+		return field.ErrorList{field.Forbidden(field.NewPath("spec").Child("dependencies"), err.Error())}
+	}
+
+	return nil
+}
+
+// validateHelm rejects value sources that don't reference exactly one of a configmap or a secret
+func (r *Environment) validateHelm() field.ErrorList {
+	var allErrs field.ErrorList
+	sourcePaths := r.Spec.sourcePaths()
+	for i, source := range r.Spec.SourceApplications() {
+		allErrs = append(allErrs, validateHelmSpec(source.Helm, sourcePaths[i].Child("helm"))...)
+	}
+	for i, dependency := range r.Spec.Dependencies {
+		allErrs = append(allErrs, validateHelmSpec(dependency.Helm, field.NewPath("spec").Child("dependencies").Index(i).Child("helm"))...)
+	}
+
+	return allErrs
+}
+
+func validateHelmSpec(helm *HelmSpec, helmPath *field.Path) field.ErrorList {
+	if helm == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	for i := range helm.ValuesFrom {
+		allErrs = append(allErrs, validateValueSource(&helm.ValuesFrom[i], helmPath.Child("valuesFrom").Index(i))...)
+	}
+	for i, parameter := range helm.Parameters {
+		if parameter.ValueFrom != nil {
+			allErrs = append(allErrs, validateValueSource(parameter.ValueFrom, helmPath.Child("parameters").Index(i).Child("valueFrom"))...)
+		}
+	}
+
+	return allErrs
+}
+
+// validateTools rejects applications that configure more than one of helm, kustomize and directory
+func (r *Environment) validateTools() field.ErrorList {
+	var allErrs field.ErrorList
+	sourcePaths := r.Spec.sourcePaths()
+	for i, source := range r.Spec.SourceApplications() {
+		allErrs = append(allErrs, validateTool(source.Helm != nil || source.ReleaseName != "", source.Kustomize != nil, source.Directory != nil,
+			sourcePaths[i])...)
+	}
+	for i, dependency := range r.Spec.Dependencies {
+		allErrs = append(allErrs, validateTool(dependency.Helm != nil || dependency.ReleaseName != "", dependency.Kustomize != nil, dependency.Directory != nil,
+			field.NewPath("spec").Child("dependencies").Index(i))...)
+	}
+
+	return allErrs
+}
+
+// validateImageOverrides rejects overrides without exactly one of tag and digest, overrides of directory sources,
+// digests of helm sources without a digest helm value and several overrides of a helm source written to the
+// default helm values
+func (r *Environment) validateImageOverrides() field.ErrorList {
+	var allErrs field.ErrorList
+	sourcePaths := r.Spec.sourcePaths()
+	for i, source := range r.Spec.SourceApplications() {
+		overridesPath := sourcePaths[i].Child("imageOverrides")
+		if len(source.ImageOverrides) > 0 && source.Directory != nil {
+			allErrs = append(allErrs, field.Forbidden(overridesPath, "images of directory sources can't be overridden, use helm or kustomize"))
+		}
+
+		defaultParameters := 0
+		for j, override := range source.ImageOverrides {
+			if (override.Tag == "") == (override.Digest == "") {
+				allErrs = append(allErrs, field.Invalid(overridesPath.Index(j), override.Name, "exactly one of tag and digest must be set"))
+			}
+
+			// overrides of helm sources are written to helm values, the others are kustomize images matched by image name
+			if !source.isHelm() {
+				continue
+			}
+			// a digest can't be written to the tag value, it would render as `repository:sha256:...`
+			if override.Digest != "" && (override.HelmParameters == nil || override.HelmParameters.Digest == "") {
+				allErrs = append(allErrs, field.Required(overridesPath.Index(j).Child("helmParameters", "digest"),
+					"digests of helm sources need the helm value the chart reads the digest from"))
+			}
+			if override.HelmParameters == nil {
+				defaultParameters++
+				if defaultParameters > 1 {
+					allErrs = append(allErrs, field.Required(overridesPath.Index(j).Child("helmParameters"),
+						"only one override of a helm source can use the default helm values, set helmParameters"))
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// isHelm returns true if the controller deploys the source with helm parameters, the same test applies
+// the image overrides to the argocd application
+func (in *AppSrc) isHelm() bool {
+	return in.Helm != nil || in.ReleaseName != "" || in.ChartName != ""
+}
+
+// validateNodePools rejects node pools the provider can't create, unknown profiles and inconsistent autoscaling limits.
+// Profiles are only looked up when checkProfiles is set.
+func (r *Environment) validateNodePools(checkProfiles bool) field.ErrorList {
+	if len(r.Spec.NodePools) == 0 {
+		return nil
+	}
+
+	nodePoolsPath := field.NewPath("spec").Child("nodePools")
+	if r.Spec.Provider != "" && r.Spec.Provider != ProviderGKE {
+		return field.ErrorList{field.Forbidden(nodePoolsPath, "node pools are only supported by the gke provider")}
+	}
+	if r.Spec.Isolation != "" && r.Spec.Isolation != IsolationCluster {
+		return field.ErrorList{field.Forbidden(nodePoolsPath, "node pools require cluster isolation")}
+	}
+
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	for i, nodePool := range r.Spec.NodePools {
+		nodePoolPath := nodePoolsPath.Index(i)
+		if names[nodePool.Name] {
+			allErrs = append(allErrs, field.Duplicate(nodePoolPath.Child("name"), nodePool.Name))
+		}
+		names[nodePool.Name] = true
+
+		if checkProfiles && nodePool.ProfileRef != "" {
+			allErrs = append(allErrs, validateNodePoolProfile(nodePool.ProfileRef, nodePoolPath.Child("profileRef"))...)
+		}
+
+		if !nodePool.Autoscaling {
+			continue
+		}
+		if nodePool.MaxNodes == nil {
+			allErrs = append(allErrs, field.Required(nodePoolPath.Child("maxNodes"), "maxNodes is required when autoscaling is enabled"))
+			continue
+		}
+		if nodePool.MinNodes != nil && *nodePool.MinNodes > *nodePool.MaxNodes {
+			allErrs = append(allErrs, field.Invalid(nodePoolPath.Child("minNodes"), *nodePool.MinNodes, "minNodes can't be greater than maxNodes"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateSchedule rejects unknown timezones and invalid cron expressions
+func (r *Environment) validateSchedule() field.ErrorList {
+	schedule := r.Spec.Schedule
+	if schedule == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	schedulePath := field.NewPath("spec").Child("schedule")
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		allErrs = append(allErrs, field.Invalid(schedulePath.Child("timezone"), schedule.Timezone, err.Error()))
+	}
+	for i, window := range schedule.Windows {
+		windowPath := schedulePath.Child("windows").Index(i)
+		if _, err := cron.ParseStandard(window.Wake); err != nil {
+			allErrs = append(allErrs, field.Invalid(windowPath.Child("wake"), window.Wake, err.Error()))
+		}
+		if _, err := cron.ParseStandard(window.Sleep); err != nil {
+			allErrs = append(allErrs, field.Invalid(windowPath.Child("sleep"), window.Sleep, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateIdle rejects idle policies that would hibernate the environment right away
+func (r *Environment) validateIdle() field.ErrorList {
+	if r.Spec.Idle == nil || r.Spec.Idle.After.Duration > 0 {
+		return nil
+	}
+
+	afterPath := field.NewPath("spec").Child("idle").Child("after")
+	return field.ErrorList{field.Invalid(afterPath, r.Spec.Idle.After.Duration.String(), "must be positive")}
+}
+
+// validateBudget rejects budgets of environments the controller can't price, only the node pools of gke clusters are priced
+func (r *Environment) validateBudget() field.ErrorList {
+	if r.Spec.Budget == nil {
+		return nil
+	}
+
+	budgetPath := field.NewPath("spec").Child("budget")
+	if r.Spec.Provider != "" && r.Spec.Provider != ProviderGKE {
+		return field.ErrorList{field.Forbidden(budgetPath, "budgets are only supported by the gke provider")}
+	}
+	if r.Spec.Isolation != "" && r.Spec.Isolation != IsolationCluster {
+		return field.ErrorList{field.Forbidden(budgetPath, "budgets require cluster isolation")}
+	}
+
+	return nil
+}
+
+// validateNotify rejects notification channels that can't be delivered to
+func (r *Environment) validateNotify() field.ErrorList {
+	notify := r.Spec.Notify
+	if notify == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	notifyPath := field.NewPath("spec").Child("notify")
+	for i, threshold := range notify.BeforeExpiry {
+		if threshold.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(notifyPath.Child("beforeExpiry").Index(i), threshold.Duration.String(), "must be positive"))
+		}
+	}
+	allErrs = append(allErrs, validateWebhookNotification(notify.Webhook, notifyPath.Child("webhook"))...)
+	allErrs = append(allErrs, validateWebhookNotification(notify.Slack, notifyPath.Child("slack"))...)
+	if notify.Email != nil && len(notify.Email.To) == 0 && r.Spec.Owner == "" {
+		allErrs = append(allErrs, field.Required(notifyPath.Child("email").Child("to"), "recipients are required when the environment has no owner"))
+	}
+
+	return allErrs
+}
+
+func validateWebhookNotification(webhook *WebhookNotification, webhookPath *field.Path) field.ErrorList {
+	if webhook == nil {
+		return nil
+	}
+
+	if (webhook.URL == "") == (webhook.URLFrom == nil) {
+		return field.ErrorList{field.Invalid(webhookPath, "", "exactly one of url and urlFrom must be set")}
+	}
+	if webhook.URLFrom != nil {
+		return validateValueSource(webhook.URLFrom, webhookPath.Child("urlFrom"))
+	}
+
+	return nil
+}
+
+func validateNodePoolProfile(name string, profilePath *field.Path) field.ErrorList {
+	profile := &NodePoolProfile{}
+	if err := environmentClient.Get(context.Background(), types.NamespacedName{Name: name}, profile); err != nil {
+		if kerrors.IsNotFound(err) {
+			return field.ErrorList{field.NotFound(profilePath, name)}
+		}
+		return field.ErrorList{field.InternalError(profilePath, err)}
+	}
+
+	return nil
+}
+
+func validateTool(helm, kustomize, directory bool, appPath *field.Path) field.ErrorList {
+	tools := 0
+	for _, set := range []bool{helm, kustomize, directory} {
+		if set {
+			tools++
+		}
+	}
+
+	if tools > 1 {
+		return field.ErrorList{field.Forbidden(appPath, "only one of helm (or releaseName), kustomize and directory can be set")}
+	}
+
+	return nil
+}
+
+func validateValueSource(source *ValueSource, sourcePath *field.Path) field.ErrorList {
+	if (source.ConfigMapKeyRef == nil) == (source.SecretKeyRef == nil) {
+		return field.ErrorList{field.Invalid(sourcePath, "", "exactly one of configMapKeyRef and secretKeyRef must be set")}
+	}
+
+	return nil
+}
+
+// validateClusterName rejects cluster names that are already used by another environment
+func (r *Environment) validateClusterName() field.ErrorList {
+	if r.Spec.ClusterName == "" {
+		return nil
+	}
+
+	clusterNamePath := field.NewPath("spec").Child("clusterName")
+	envs := &EnvironmentList{}
+	if err := environmentClient.List(context.Background(), envs); err != nil {
+		return field.ErrorList{field.InternalError(clusterNamePath, err)}
+	}
+
+	for _, env := range envs.Items {
+		if env.Name != r.Name && env.Spec.ClusterName == r.Spec.ClusterName {
+			return field.ErrorList{field.Invalid(clusterNamePath, r.Spec.ClusterName, "cluster name is already used by environment "+env.Name)}
+		}
+	}
+
+	return nil
+}
+
+// validateClusterClass rejects cluster class labels that don't match a cluster class of the provider
+func (r *Environment) validateClusterClass() field.ErrorList {
+	if r.Spec.Isolation != "" && r.Spec.Isolation != IsolationCluster {
+		return nil
+	}
+
+	gvk, ok := r.Spec.Provider.ClusterClassGroupVersionKind()
+	if !ok {
+		return nil
+	}
+
+	clusterClassPath := field.NewPath("spec").Child("clusterClassLabel")
+	if r.Spec.ClusterClassLabel == "" && r.pendingTemplate() {
+		return nil
+	}
+	if r.Spec.ClusterClassLabel == "" {
+		return field.ErrorList{field.Required(clusterClassPath, "a cluster class is required to provision the cluster")}
+	}
+
+	k8class := &unstructured.Unstructured{}
+	k8class.SetGroupVersionKind(gvk)
+	if err := environmentClient.Get(context.Background(), types.NamespacedName{Name: r.Spec.ClusterClassLabel}, k8class); err != nil {
+		if kerrors.IsNotFound(err) {
+			return field.ErrorList{field.NotFound(clusterClassPath, r.Spec.ClusterClassLabel)}
+		}
+		return field.ErrorList{field.InternalError(clusterClassPath, err)}
+	}
+
+	return nil
+}
+
+// validateTemplateRef rejects unknown templates and parameters the template doesn't declare
+func (r *Environment) validateTemplateRef() field.ErrorList {
+	if r.Spec.TemplateRef == nil {
+		return nil
+	}
+
+	templateRefPath := field.NewPath("spec").Child("templateRef")
+	template := &EnvironmentTemplate{}
+	if err := environmentClient.Get(context.Background(), types.NamespacedName{Name: r.Spec.TemplateRef.Name}, template); err != nil {
+		if kerrors.IsNotFound(err) {
+			return field.ErrorList{field.NotFound(templateRefPath.Child("name"), r.Spec.TemplateRef.Name)}
+		}
+		return field.ErrorList{field.InternalError(templateRefPath, err)}
+	}
+
+	var allErrs field.ErrorList
+	parametersPath := templateRefPath.Child("parameters")
+	declared := map[string]bool{TemplateEnvironmentParameter: true}
+	for _, parameter := range template.Spec.Parameters {
+		declared[parameter.Name] = true
+		if _, ok := r.Spec.TemplateRef.Parameters[parameter.Name]; parameter.Required && !ok {
+			allErrs = append(allErrs, field.Required(parametersPath.Key(parameter.Name), "parameter is required by the template"))
+		}
+	}
+	for name := range r.Spec.TemplateRef.Parameters {
+		if !declared[name] {
+			allErrs = append(allErrs, field.NotSupported(parametersPath.Key(name), name, declaredParameters(template)))
+		}
+	}
+
+	return allErrs
+}
+
+func declaredParameters(template *EnvironmentTemplate) []string {
+	names := []string{}
+	for _, parameter := range template.Spec.Parameters {
+		names = append(names, parameter.Name)
+	}
+
+	return names
+}
+
+// pendingTemplate returns true if the spec is still to be rendered from its template
+func (r *Environment) pendingTemplate() bool {
+	return r.Spec.TemplateRef != nil && r.Status.Template == nil
+}
+
+// validateImmutableFields rejects changes to fields that decide how the cluster was provisioned.
+// Until the environment is rendered from its template, the fields it left empty may still be filled.
+func (r *Environment) validateImmutableFields(old *Environment) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	pending := old.pendingTemplate()
+	immutable := func(name, value, oldValue string) {
+		if value == oldValue || (pending && oldValue == "") {
+			return
+		}
+		allErrs = append(allErrs, field.Forbidden(specPath.Child(name), "field is immutable"))
+	}
+
+	immutable("clusterName", r.Spec.ClusterName, old.Spec.ClusterName)
+	immutable("clusterClassLabel", r.Spec.ClusterClassLabel, old.Spec.ClusterClassLabel)
+	immutable("provider", string(r.Spec.Provider), string(old.Spec.Provider))
+	immutable("isolation", string(r.Spec.Isolation), string(old.Spec.Isolation))
+	if old.Spec.TemplateRef != nil && !equality.Semantic.DeepEqual(r.Spec.TemplateRef, old.Spec.TemplateRef) && !pending {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("templateRef"), "field is immutable once the environment was rendered from the template"))
+	}
+
+	return allErrs
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// useFakeClient points the webhooks at a fake client holding the objects
+func useFakeClient(t *testing.T, objs ...runtime.Object) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	environmentClient = fake.NewFakeClientWithScheme(scheme, objs...)
+}
+
+// newWebhookEnvironment returns a valid environment of the namespace isolation mode, which needs no cluster class
+func newWebhookEnvironment(name string) *Environment {
+	return &Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec: EnvironmentSpec{
+			ClusterName: name,
+			Isolation:   IsolationNamespace,
+			Source: &AppSrc{
+				Name:    "app",
+				RepoURL: "https://github.com/example/app",
+				Path:    "deploy",
+			},
+		},
+	}
+}
+
+func TestValidateUpdateClusterName(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name    string
+		update  func(env *Environment)
+		wantErr bool
+	}{
+		{
+			name: "update that keeps the cluster name isn't checked against other environments",
+			update: func(env *Environment) {
+				env.Annotations = map[string]string{"example.com/note": "updated"}
+			},
+		},
+		{
+			name: "finalizers are removed from a deleted environment",
+			update: func(env *Environment) {
+				env.DeletionTimestamp = &now
+				env.Finalizers = nil
+			},
+		},
+		{
+			name: "cluster name of another environment is rejected",
+			update: func(env *Environment) {
+				env.Spec.ClusterName = "other"
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newWebhookEnvironment("shared")
+			old.Finalizers = []string{"example.com/finalizer"}
+			// environments created before cluster names were checked may share them
+			other := newWebhookEnvironment("other")
+			other.Spec.ClusterName = "shared"
+			useFakeClient(t, old, other)
+
+			env := old.DeepCopy()
+			tt.update(env)
+			if err := env.ValidateUpdate(old); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateImageOverrides(t *testing.T) {
+	tag := func(name string) ImageOverride { return ImageOverride{Name: name, Tag: "v2"} }
+	digest := ImageOverride{Name: "example/api", Digest: "sha256:0123456789abcdef"}
+
+	tests := []struct {
+		name      string
+		source    AppSrc
+		overrides []ImageOverride
+		wantErr   bool
+	}{
+		{
+			name:      "plain path source overrides several images",
+			overrides: []ImageOverride{tag("example/api"), tag("example/worker")},
+		},
+		{
+			name:      "kustomize source overrides several images",
+			source:    AppSrc{Kustomize: &KustomizeSpec{}},
+			overrides: []ImageOverride{tag("example/api"), tag("example/worker")},
+		},
+		{
+			name:      "chart overrides one image with the default helm values",
+			source:    AppSrc{ChartName: "api"},
+			overrides: []ImageOverride{tag("example/api")},
+		},
+		{
+			name:      "chart can't write several images to the default helm values",
+			source:    AppSrc{ChartName: "api"},
+			overrides: []ImageOverride{tag("example/api"), tag("example/worker")},
+			wantErr:   true,
+		},
+		{
+			name:      "helm source can't write a digest to the tag",
+			source:    AppSrc{Helm: &HelmSpec{}},
+			overrides: []ImageOverride{digest},
+			wantErr:   true,
+		},
+		{
+			name:   "helm source writes a digest to its helm value",
+			source: AppSrc{ReleaseName: "api"},
+			overrides: []ImageOverride{func() ImageOverride {
+				override := digest
+				override.HelmParameters = &ImageHelmParameters{Digest: "image.digest"}
+				return override
+			}()},
+		},
+		{
+			name:      "kustomize source overrides a digest",
+			source:    AppSrc{Kustomize: &KustomizeSpec{}},
+			overrides: []ImageOverride{digest},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newWebhookEnvironment("test")
+			tt.source.Name, tt.source.RepoURL, tt.source.Path = "app", "https://github.com/example/app", "deploy"
+			tt.source.ImageOverrides = tt.overrides
+			env.Spec.Source = &tt.source
+
+			if errs := env.validateImageOverrides(); (len(errs) > 0) != tt.wantErr {
+				t.Errorf("got errors %v, want errors %t", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateNodePoolProfiles(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name    string
+		update  func(env *Environment)
+		wantErr bool
+	}{
+		{
+			name: "deleted profile doesn't block updates of other fields",
+			update: func(env *Environment) {
+				env.Annotations = map[string]string{"example.com/note": "updated"}
+			},
+		},
+		{
+			name: "deleted profile doesn't block the removal of finalizers",
+			update: func(env *Environment) {
+				env.DeletionTimestamp = &now
+				env.Finalizers = nil
+			},
+		},
+		{
+			name: "changed node pools are checked against the profiles",
+			update: func(env *Environment) {
+				env.Spec.NodePools = append(env.Spec.NodePools, NodePoolSpec{Name: "gpu"})
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newWebhookEnvironment("test")
+			old.Finalizers = []string{"example.com/finalizer"}
+			old.Spec.Isolation = IsolationCluster
+			old.Spec.Provider = ProviderGKE
+			old.Spec.NodePools = []NodePoolSpec{{Name: "default", ProfileRef: "deleted"}}
+			useFakeClient(t, old)
+
+			env := old.DeepCopy()
+			tt.update(env)
+			if err := env.ValidateUpdate(old); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TemplateEnvironmentParameter is the built-in template parameter set to the name of the environment
+const TemplateEnvironmentParameter = "environment"
+
+// EnvironmentTemplateSpec defines a reusable blueprint of environments
+type EnvironmentTemplateSpec struct {
+	// Parameters are the parameters environments pass to the template.
+	// `$(environment)` is always available and resolves to the name of the environment.
+	// +optional
+	Parameters []TemplateParameter `json:"parameters,omitempty"`
+
+	// Template is the spec environments referencing the template start from.
+	// String fields can use parameters as `$(name)`, fields validated with a pattern or an enum
+	// (e.g., ttl) can't. Fields set on the environment override the template.
+	Template EnvironmentSpec `json:"template"`
+}
+
+// TemplateParameter declares a parameter of an environment template
+type TemplateParameter struct {
+	// Name of the parameter, referenced as `$(name)` in the template
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z][a-zA-Z0-9_-]*$`
+	Name string `json:"name"`
+
+	// Description of the parameter
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Default is used when the environment doesn't set the parameter
+	// +optional
+	Default string `json:"default,omitempty"`
+
+	// Required parameters have to be set by the environment
+	// +optional
+	Required bool `json:"required,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Generation",type=integer,JSONPath=`.metadata.generation`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// EnvironmentTemplate is the Schema for the environmenttemplates API
+type EnvironmentTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EnvironmentTemplateSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// EnvironmentTemplateList contains a list of EnvironmentTemplate
+type EnvironmentTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EnvironmentTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EnvironmentTemplate{}, &EnvironmentTemplateList{})
+}
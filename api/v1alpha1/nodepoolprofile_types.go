@@ -0,0 +1,49 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Machine Type",type=string,JSONPath=`.spec.machineType`
+// +kubebuilder:printcolumn:name="Preemptible",type=boolean,JSONPath=`.spec.preemptible`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// NodePoolProfile is a node configuration shared by the node pools of environments (e.g., load-testing
+// or cheap preview hardware). Node pools reference it with `profileRef`.
+type NodePoolProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NodeConfig `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// NodePoolProfileList contains a list of NodePoolProfile
+type NodePoolProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodePoolProfile `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodePoolProfile{}, &NodePoolProfileList{})
+}
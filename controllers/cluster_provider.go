@@ -0,0 +1,124 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// ClusterProvider provisions the kubernetes cluster an environment deploys its applications to
+type ClusterProvider interface {
+	// FetchClusterClass returns the cluster class referenced by `spec.clusterClassLabel`
+	FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error)
+
+	// CreateClusterClaim creates the cluster claim if it doesn't exist yet, corrects its drift and
+	// returns the name of the managed cluster bound to it (empty if the claim is not bound yet)
+	CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error)
+
+	// CreateNodePools creates, updates and deletes the node pools of the managed cluster to match the spec
+	CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error
+
+	// IsClusterReady returns true once the cluster can receive applications
+	IsClusterReady(env *devv1alpha1.Environment) bool
+
+	// AreNodePoolsReady returns true once the node pools of the cluster are running
+	AreNodePoolsReady(env *devv1alpha1.Environment) bool
+}
+
+// ClusterFinalizer is implemented by providers whose clusters can't be garbage collected
+// through owner references and have to be deleted explicitly before the environment is removed
+type ClusterFinalizer interface {
+	// Finalizer returns the finalizer added to environments using the provider
+	Finalizer() string
+
+	// DeleteCluster deletes the cluster of the environment
+	DeleteCluster(env *devv1alpha1.Environment) error
+}
+
+// NodePoolDeleter is implemented by providers that create node pools for the cluster,
+// they're deleted before the cluster when the environment is deleted
+type NodePoolDeleter interface {
+	// DeleteNodePools deletes the node pools of the environment and returns the ones that still exist
+	DeleteNodePools(env *devv1alpha1.Environment) ([]string, error)
+}
+
+// MachineCounter is implemented by providers whose cost depends on the machines of their node pools
+type MachineCounter interface {
+	// Machines returns the number of machines of each machine type the environment runs at most,
+	// preemptible machines have PreemptiblePriceSuffix appended to their machine type
+	Machines(env *devv1alpha1.Environment) (map[string]int64, error)
+}
+
+// ClusterSleeper is implemented by providers that can scale the cluster itself down while the
+// environment sleeps. Environments of other providers have their workloads scaled down instead.
+type ClusterSleeper interface {
+	// SleepCluster scales the cluster of the environment down
+	SleepCluster(env *devv1alpha1.Environment) error
+
+	// WakeCluster undoes SleepCluster
+	WakeCluster(env *devv1alpha1.Environment) error
+}
+
+// clusterProvider returns the ClusterProvider selected by `spec.isolation` and `spec.provider`
+func (r *EnvironmentReconciler) clusterProvider(env *devv1alpha1.Environment) (ClusterProvider, error) {
+	switch env.Spec.Isolation {
+	case devv1alpha1.IsolationNamespace:
+		return &namespaceClusterProvider{r, nil}, nil
+	case devv1alpha1.IsolationVCluster:
+		return &namespaceClusterProvider{r, &vclusterProvider{r}}, nil
+	}
+
+	switch env.Spec.Provider {
+	case "", devv1alpha1.ProviderGKE:
+		return &gkeClusterProvider{r}, nil
+	case devv1alpha1.ProviderEKS:
+		return &eksClusterProvider{r}, nil
+	case devv1alpha1.ProviderAKS:
+		return &aksClusterProvider{r}, nil
+	case devv1alpha1.ProviderKind:
+		return &localClusterProvider{r, kindTool{}}, nil
+	case devv1alpha1.ProviderK3d:
+		return &localClusterProvider{r, k3dTool{}}, nil
+	case devv1alpha1.ProviderVCluster:
+		return &vclusterProvider{r}, nil
+	}
+
+	return nil, fmt.Errorf("unknown cluster provider '%s'", env.Spec.Provider)
+}
+
+// fetchUnstructuredClusterClass fetches the cluster class of a crossplane provider that isn't a
+// dependency of the controller (provider-aws, provider-azure), so its go types aren't in the scheme
+func (r *EnvironmentReconciler) fetchUnstructuredClusterClass(env *devv1alpha1.Environment, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	k8class := &unstructured.Unstructured{}
+	k8class.SetGroupVersionKind(gvk)
+	k8classNamespacedName := types.NamespacedName{
+		Name: env.Spec.ClusterClassLabel,
+	}
+	if err := r.Client.Get(context.Background(), k8classNamespacedName, k8class); err != nil {
+		return nil, err
+	}
+
+	return k8class, nil
+}
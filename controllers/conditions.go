@@ -0,0 +1,244 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// ttlExpiringThreshold is how long before the TTL is exceeded the TTLExpiring condition turns true
+const ttlExpiringThreshold = time.Hour
+
+// parseTTL converts `spec.ttl` (e.g., 5m, 1h, 2d, 1y) into a duration
+func parseTTL(ttl string) time.Duration {
+	if ttl == "" {
+		return 0
+	}
+
+	ttlDuration, _ := strconv.Atoi(ttl[:len(ttl)-1])
+	switch ttl[len(ttl)-1:] {
+	case "m":
+		return time.Duration(ttlDuration) * time.Minute
+	case "h":
+		return time.Duration(ttlDuration) * time.Hour
+	case "d":
+		return time.Duration(ttlDuration) * time.Hour * 24
+	case "y":
+		return time.Duration(ttlDuration) * time.Hour * 24 * 365
+	}
+
+	return 0
+}
+
+// setConditions computes the conditions of the environment from the cluster and its argocd applications
+func (r *EnvironmentReconciler) setConditions(env *devv1alpha1.Environment, provider ClusterProvider) {
+	setCondition := func(conditionType devv1alpha1.ConditionType, ok bool, reason, message string) {
+		status := metav1.ConditionFalse
+		if ok {
+			status = metav1.ConditionTrue
+		}
+		devv1alpha1.SetCondition(&env.Status.Conditions, devv1alpha1.Condition{
+			Type:               conditionType,
+			Status:             status,
+			ObservedGeneration: env.GetGeneration(),
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+
+	clusterReady := provider.IsClusterReady(env)
+	if clusterReady {
+		setCondition(devv1alpha1.ConditionClusterProvisioned, true, "Provisioned", fmt.Sprintf("cluster '%s' is ready", env.Spec.ClusterName))
+	} else {
+		setCondition(devv1alpha1.ConditionClusterProvisioned, false, "Provisioning", fmt.Sprintf("waiting for cluster '%s' to be provisioned", env.Spec.ClusterName))
+	}
+
+	if provider.AreNodePoolsReady(env) {
+		setCondition(devv1alpha1.ConditionNodePoolReady, true, "Running", "node pools are running")
+	} else {
+		setCondition(devv1alpha1.ConditionNodePoolReady, false, "Provisioning", "waiting for node pools to be running")
+	}
+
+	env.Status.Sources = r.sourceStatuses(env)
+	notReadySources := notReadyApplications(env.Status.Sources)
+	sourceReady := len(notReadySources) == 0 && len(env.Status.Sources) > 0
+	sourceMessage := fmt.Sprintf("waiting for sources: %s", strings.Join(notReadySources, ", "))
+	switch {
+	case len(env.Status.Sources) == 0:
+		sourceMessage = "environment has no source"
+		setCondition(devv1alpha1.ConditionSourceSynced, false, "NoSource", sourceMessage)
+	case sourceReady:
+		setCondition(devv1alpha1.ConditionSourceSynced, true, "Synced", "all sources are synced and healthy")
+	default:
+		setCondition(devv1alpha1.ConditionSourceSynced, false, "NotSynced", sourceMessage)
+	}
+
+	env.Status.Dependencies = r.dependencyStatuses(env)
+	notReadyDependencies := notReadyApplications(env.Status.Dependencies)
+	dependenciesReady := len(notReadyDependencies) == 0
+	if dependenciesReady {
+		setCondition(devv1alpha1.ConditionDependenciesHealthy, true, "Healthy", "all dependencies are synced and healthy")
+	} else {
+		setCondition(devv1alpha1.ConditionDependenciesHealthy, false, "Unhealthy", fmt.Sprintf("waiting for dependencies: %s", strings.Join(notReadyDependencies, ", ")))
+	}
+
+	r.setTTLCondition(env, setCondition)
+	r.setTemplateCondition(env, setCondition)
+
+	switch {
+	case env.Status.Phase == devv1alpha1.PhaseSleeping && isOverBudget(env):
+		setCondition(devv1alpha1.ConditionReady, false, "BudgetExhausted", fmt.Sprintf("environment accrued a cost of %s and exhausted its budget of %s",
+			env.Status.Cost.Accrued, env.Spec.Budget.Limit))
+	case env.Status.Phase == devv1alpha1.PhaseSleeping && scheduledAwake(env) && env.Status.LastActivity != nil:
+		setCondition(devv1alpha1.ConditionReady, false, "Idle", fmt.Sprintf("environment is hibernating, it wasn't used since %s",
+			env.Status.LastActivity.UTC().Format(time.RFC3339)))
+	case env.Status.Phase == devv1alpha1.PhaseSleeping:
+		setCondition(devv1alpha1.ConditionReady, false, "Sleeping", "environment is sleeping outside the active windows of its schedule")
+	case !clusterReady:
+		setCondition(devv1alpha1.ConditionReady, false, "ClusterNotProvisioned", "waiting for the cluster to be provisioned")
+	case !sourceReady:
+		setCondition(devv1alpha1.ConditionReady, false, "SourceNotSynced", sourceMessage)
+	case !dependenciesReady:
+		setCondition(devv1alpha1.ConditionReady, false, "DependenciesNotHealthy", fmt.Sprintf("waiting for dependencies: %s", strings.Join(notReadyDependencies, ", ")))
+	default:
+		setCondition(devv1alpha1.ConditionReady, true, "Ready", "environment is ready")
+	}
+}
+
+// scheduledAwake returns true if the schedule of the environment doesn't put it to sleep right now
+func scheduledAwake(env *devv1alpha1.Environment) bool {
+	awake, err := isAwake(env.Spec.Schedule, time.Now())
+	return err == nil && awake
+}
+
+// ttlExpiresAt returns when the TTL of a started TTL is exceeded, including its extensions
+func ttlExpiresAt(env *devv1alpha1.Environment) time.Time {
+	expiresAt := env.Status.TTLStartTimestamp.Add(parseTTL(env.Spec.TTL))
+	if env.Status.TTLExtension != nil {
+		expiresAt = expiresAt.Add(env.Status.TTLExtension.Duration)
+	}
+
+	return expiresAt
+}
+
+// extendTTL adds the duration of the extend-ttl annotation to the TTL extension and removes the annotation.
+// Invalid durations are logged and dropped, so the annotation doesn't block the environment.
+func (r *EnvironmentReconciler) extendTTL(env *devv1alpha1.Environment) error {
+	extension, ok := env.GetAnnotations()[ExtendTTLAnnotation]
+	if !ok {
+		return nil
+	}
+
+	status := env.Status.DeepCopy()
+	duration := parseTTL(extension)
+	if duration <= 0 {
+		r.Log.Info("ignoring invalid TTL extension, expected a duration like 30m, 4h or 2d", "extension", extension)
+	} else {
+		r.Log.Info("extending the TTL of the environment", "extension", extension)
+		if status.TTLExtension == nil {
+			status.TTLExtension = &metav1.Duration{}
+		}
+		status.TTLExtension.Duration += duration
+	}
+
+	annotations := env.GetAnnotations()
+	delete(annotations, ExtendTTLAnnotation)
+	env.SetAnnotations(annotations)
+	if err := r.Update(context.Background(), env); err != nil {
+		r.Log.Error(err, "could not remove the extend-ttl annotation from the environment")
+		return err
+	}
+
+	// the update overwrote the status with the stored one
+	env.Status = *status
+	return nil
+}
+
+// warnBeforeExpiry notifies the owner once the time left crosses one of the warning thresholds.
+// Only the smallest crossed threshold is sent, so a late reconcile doesn't send all of them at once.
+func (r *EnvironmentReconciler) warnBeforeExpiry(env *devv1alpha1.Environment, expiresAt time.Time) {
+	remaining := time.Until(expiresAt)
+	crossed := time.Duration(-1)
+	for _, threshold := range r.ttlWarningThresholds(env) {
+		if remaining <= threshold && (crossed < 0 || threshold < crossed) {
+			crossed = threshold
+		}
+	}
+	if crossed < 0 {
+		return
+	}
+
+	// compared in seconds, the status doesn't keep sub-second precision
+	last := env.Status.LastTTLWarning
+	if last != nil && last.ExpiresAt.Unix() == expiresAt.Unix() && last.BeforeExpiry.Duration <= crossed {
+		return
+	}
+
+	r.notify(env, "TTLExpiring", fmt.Sprintf("environment '%s' expires in %s (at %s), extend it with the %s annotation",
+		env.GetName(), remaining.Round(time.Minute), expiresAt.UTC().Format(time.RFC3339), ExtendTTLAnnotation))
+	env.Status.LastTTLWarning = &devv1alpha1.TTLWarning{
+		ExpiresAt:    metav1.NewTime(expiresAt),
+		BeforeExpiry: metav1.Duration{Duration: crossed},
+	}
+}
+
+func (r *EnvironmentReconciler) ttlWarningThresholds(env *devv1alpha1.Environment) []time.Duration {
+	if env.Spec.Notify == nil || len(env.Spec.Notify.BeforeExpiry) == 0 {
+		return r.TTLWarnings
+	}
+
+	thresholds := []time.Duration{}
+	for _, threshold := range env.Spec.Notify.BeforeExpiry {
+		thresholds = append(thresholds, threshold.Duration)
+	}
+
+	return thresholds
+}
+
+func (r *EnvironmentReconciler) setTTLCondition(env *devv1alpha1.Environment, setCondition func(devv1alpha1.ConditionType, bool, string, string)) {
+	if env.Spec.TTL == "" {
+		setCondition(devv1alpha1.ConditionTTLExpiring, false, "NoTTL", "environment has no TTL")
+		return
+	}
+
+	if env.Status.TTLStartTimestamp.IsZero() {
+		setCondition(devv1alpha1.ConditionTTLExpiring, false, "TTLNotStarted", "TTL starts once the environment is ready")
+		return
+	}
+
+	if env.Spec.SuspendTTL {
+		setCondition(devv1alpha1.ConditionTTLExpiring, false, "TTLSuspended", "TTL is suspended")
+		return
+	}
+
+	expiresAt := ttlExpiresAt(env)
+	message := fmt.Sprintf("environment expires at %s", expiresAt.UTC().Format(time.RFC3339))
+	if time.Until(expiresAt) < ttlExpiringThreshold {
+		setCondition(devv1alpha1.ConditionTTLExpiring, true, "TTLExpiring", message)
+		return
+	}
+
+	setCondition(devv1alpha1.ConditionTTLExpiring, false, "TTLNotExpiring", message)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+const (
+	// PreemptiblePriceSuffix is appended to a machine type in the price table for its preemptible price,
+	// preemptible machines without one are priced like regular machines
+	PreemptiblePriceSuffix = ".preemptible"
+
+	// defaultBudgetWarnAtPercent is the share of the budget a warning is sent at unless the budget sets one
+	defaultBudgetWarnAtPercent = 80
+)
+
+// PriceTable maps machine types (e.g., n1-standard-4) to their price per hour. It's read from a
+// ConfigMap whose keys are the machine types and whose values are the prices, e.g., `n1-standard-4: "0.19"`.
+type PriceTable map[string]float64
+
+// parsePriceTable reads the prices of the data of the price table ConfigMap
+func parsePriceTable(data map[string]string) (PriceTable, error) {
+	prices := PriceTable{}
+	for machineType, value := range data {
+		price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || price < 0 {
+			return nil, fmt.Errorf("invalid price '%s' of machine type '%s'", value, machineType)
+		}
+		prices[machineType] = price
+	}
+
+	return prices, nil
+}
+
+// price returns the price per hour of the machine type
+func (t PriceTable) price(machineType string) (float64, bool) {
+	if price, ok := t[machineType]; ok {
+		return price, true
+	}
+
+	price, ok := t[strings.TrimSuffix(machineType, PreemptiblePriceSuffix)]
+	return price, ok
+}
+
+// estimateHourlyCost returns the cost per hour of the machines (machine type to count) and the machine types without a price
+func estimateHourlyCost(machines map[string]int64, prices PriceTable) (float64, []string) {
+	estimate := 0.0
+	unpriced := []string{}
+	for machineType, count := range machines {
+		price, ok := prices.price(machineType)
+		if !ok {
+			unpriced = append(unpriced, machineType)
+			continue
+		}
+		estimate += price * float64(count)
+	}
+	sort.Strings(unpriced)
+
+	return estimate, unpriced
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 6, 64)
+}
+
+// priceTable returns the prices of the price table ConfigMap of the controller
+func (r *EnvironmentReconciler) priceTable() (PriceTable, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := r.Client.Get(context.Background(), r.PriceTable, configMap); err != nil {
+		return nil, fmt.Errorf("could not get price table '%s': %v", r.PriceTable, err)
+	}
+
+	return parsePriceTable(configMap.Data)
+}
+
+// updateCost accrues the cost since the last update with the previous estimate and estimates the
+// current cost of the environment. Environments aren't priced when the controller has no price table.
+func (r *EnvironmentReconciler) updateCost(pc *phaseContext, now time.Time) error {
+	env := pc.env
+	if r.PriceTable.Name == "" {
+		env.Status.Cost = nil
+		return nil
+	}
+
+	machines := map[string]int64{}
+	if counter, ok := pc.provider.(MachineCounter); ok {
+		var err error
+		if machines, err = counter.Machines(env); err != nil {
+			return err
+		}
+	}
+
+	prices, err := r.priceTable()
+	if err != nil {
+		return err
+	}
+	estimate, unpriced := estimateHourlyCost(machines, prices)
+
+	cost := env.Status.Cost
+	if cost == nil {
+		cost = &devv1alpha1.CostStatus{Accrued: formatCost(0)}
+	} else if _, sleepsCluster := pc.provider.(ClusterSleeper); !sleepsCluster || env.Status.Phase != devv1alpha1.PhaseSleeping {
+		// providers that sleep the cluster delete its node pools, the others keep their nodes while they sleep
+		previousEstimate, _ := strconv.ParseFloat(cost.HourlyEstimate, 64)
+		accrued, _ := strconv.ParseFloat(cost.Accrued, 64)
+		accrued += previousEstimate * now.Sub(cost.LastAccrued.Time).Hours()
+		cost.Accrued = formatCost(accrued)
+	}
+
+	cost.HourlyEstimate = formatCost(estimate)
+	cost.LastAccrued = metav1.NewTime(now)
+	cost.Unpriced = unpriced
+	env.Status.Cost = cost
+	return nil
+}
+
+// budgetUsage returns the accrued cost and the budget limit of the environment, false if either is unknown
+func budgetUsage(env *devv1alpha1.Environment) (float64, float64, bool) {
+	if env.Spec.Budget == nil || env.Status.Cost == nil {
+		return 0, 0, false
+	}
+
+	limit, err := strconv.ParseFloat(env.Spec.Budget.Limit, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	accrued, err := strconv.ParseFloat(env.Status.Cost.Accrued, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return accrued, limit, true
+}
+
+// isOverBudget returns true once the environment accrued its budget
+func isOverBudget(env *devv1alpha1.Environment) bool {
+	accrued, limit, ok := budgetUsage(env)
+	return ok && accrued >= limit
+}
+
+// warnBeforeBudget warns the owner once the environment crosses the warning threshold of its budget,
+// once per limit
+func (r *EnvironmentReconciler) warnBeforeBudget(env *devv1alpha1.Environment) {
+	accrued, limit, ok := budgetUsage(env)
+	if !ok || env.Status.Cost.BudgetWarning == env.Spec.Budget.Limit {
+		return
+	}
+
+	warnAtPercent := env.Spec.Budget.WarnAtPercent
+	if warnAtPercent == 0 {
+		warnAtPercent = defaultBudgetWarnAtPercent
+	}
+	if accrued < limit*float64(warnAtPercent)/100 {
+		return
+	}
+
+	r.notify(env, "BudgetWarning", fmt.Sprintf("environment '%s' accrued %.2f of its budget of %s", env.GetName(), accrued, env.Spec.Budget.Limit))
+	env.Status.Cost.BudgetWarning = env.Spec.Budget.Limit
+}
+
+// exhaustBudget hibernates or deletes an environment that accrued its budget
+func (r *EnvironmentReconciler) exhaustBudget(env *devv1alpha1.Environment) (devv1alpha1.EnvironmentPhase, error) {
+	if env.Spec.Budget.Action == devv1alpha1.BudgetActionDelete {
+		r.notify(env, "BudgetExhausted", fmt.Sprintf("environment '%s' exhausted its budget of %s and is being deleted", env.GetName(), env.Spec.Budget.Limit))
+		deleteErr := r.Delete(context.Background(), env)
+		if deleteErr != nil && !kerrors.IsNotFound(deleteErr) {
+			r.Log.Error(deleteErr, "could not delete the environment that exhausted its budget")
+			return devv1alpha1.PhasePending, deleteErr
+		}
+
+		return devv1alpha1.PhaseDeleting, nil
+	}
+
+	if env.Status.Phase != devv1alpha1.PhaseSleeping {
+		r.notify(env, "BudgetExhausted", fmt.Sprintf("environment '%s' exhausted its budget of %s and sleeps until the budget is raised", env.GetName(), env.Spec.Budget.Limit))
+	}
+
+	return devv1alpha1.PhaseSleeping, nil
+}
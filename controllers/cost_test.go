@@ -0,0 +1,176 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// testPriceTable is the price table ConfigMap the tests estimate costs with
+var testPriceTable = types.NamespacedName{Namespace: "devenv-system", Name: "price-table"}
+
+// stubMachineProvider is a stubProvider running a fixed set of machines
+type stubMachineProvider struct {
+	stubProvider
+	machines map[string]int64
+}
+
+func (p *stubMachineProvider) Machines(env *devv1alpha1.Environment) (map[string]int64, error) {
+	return p.machines, nil
+}
+
+// stubSleepingProvider is a stubMachineProvider that deletes its machines while it sleeps
+type stubSleepingProvider struct {
+	stubMachineProvider
+}
+
+func (p *stubSleepingProvider) SleepCluster(env *devv1alpha1.Environment) error {
+	return nil
+}
+
+func (p *stubSleepingProvider) WakeCluster(env *devv1alpha1.Environment) error {
+	return nil
+}
+
+func TestUpdateCost(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	machines := map[string]int64{
+		"n1-standard-4":                          2,
+		"n1-standard-4" + PreemptiblePriceSuffix: 1,
+		"e2-medium":                              3,
+	}
+	previousCost := &devv1alpha1.CostStatus{
+		HourlyEstimate: "1.000000",
+		Accrued:        "5.000000",
+		LastAccrued:    metav1.NewTime(now.Add(-2 * time.Hour)),
+	}
+
+	tests := []struct {
+		name         string
+		provider     ClusterProvider
+		phase        devv1alpha1.EnvironmentPhase
+		cost         *devv1alpha1.CostStatus
+		wantEstimate string
+		wantAccrued  string
+		wantUnpriced []string
+	}{
+		{
+			name:         "first estimate accrues nothing",
+			provider:     &stubMachineProvider{machines: machines},
+			phase:        devv1alpha1.PhaseReady,
+			wantEstimate: "0.480000",
+			wantAccrued:  "0.000000",
+			wantUnpriced: []string{"e2-medium"},
+		},
+		{
+			name:         "previous estimate accrues until now",
+			provider:     &stubMachineProvider{machines: machines},
+			phase:        devv1alpha1.PhaseReady,
+			cost:         previousCost,
+			wantEstimate: "0.480000",
+			wantAccrued:  "7.000000",
+			wantUnpriced: []string{"e2-medium"},
+		},
+		{
+			name:         "provider that keeps its nodes accrues while sleeping",
+			provider:     &stubMachineProvider{machines: machines},
+			phase:        devv1alpha1.PhaseSleeping,
+			cost:         previousCost,
+			wantEstimate: "0.480000",
+			wantAccrued:  "7.000000",
+			wantUnpriced: []string{"e2-medium"},
+		},
+		{
+			name:         "provider that deletes its nodes accrues nothing while sleeping",
+			provider:     &stubSleepingProvider{stubMachineProvider{machines: map[string]int64{}}},
+			phase:        devv1alpha1.PhaseSleeping,
+			cost:         previousCost,
+			wantEstimate: "0.000000",
+			wantAccrued:  "5.000000",
+			wantUnpriced: []string{},
+		},
+		{
+			name:         "provider without machines is free",
+			provider:     &stubProvider{},
+			phase:        devv1alpha1.PhaseReady,
+			wantEstimate: "0.000000",
+			wantAccrued:  "0.000000",
+			wantUnpriced: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment()
+			env.Status.Phase = tt.phase
+			if tt.cost != nil {
+				env.Status.Cost = tt.cost.DeepCopy()
+			}
+			r := newTestReconciler(t, env, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testPriceTable.Namespace, Name: testPriceTable.Name},
+				Data: map[string]string{
+					"n1-standard-4":                          "0.19",
+					"n1-standard-4" + PreemptiblePriceSuffix: "0.10",
+				},
+			})
+			r.PriceTable = testPriceTable
+
+			if err := r.updateCost(&phaseContext{env: env, provider: tt.provider}, now); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cost := env.Status.Cost
+			if cost.HourlyEstimate != tt.wantEstimate {
+				t.Errorf("got hourly estimate %s, want %s", cost.HourlyEstimate, tt.wantEstimate)
+			}
+			if cost.Accrued != tt.wantAccrued {
+				t.Errorf("got accrued %s, want %s", cost.Accrued, tt.wantAccrued)
+			}
+			if !cost.LastAccrued.Time.Equal(now) {
+				t.Errorf("got last accrued %s, want %s", cost.LastAccrued, now)
+			}
+			if len(cost.Unpriced) != len(tt.wantUnpriced) || (len(tt.wantUnpriced) > 0 && cost.Unpriced[0] != tt.wantUnpriced[0]) {
+				t.Errorf("got unpriced %v, want %v", cost.Unpriced, tt.wantUnpriced)
+			}
+		})
+	}
+}
+
+func TestUpdateCostWithoutPriceTable(t *testing.T) {
+	env := newTestEnvironment()
+	env.Status.Cost = &devv1alpha1.CostStatus{Accrued: "1.000000"}
+	r := newTestReconciler(t, env)
+
+	if err := r.updateCost(&phaseContext{env: env, provider: &stubMachineProvider{}}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Status.Cost != nil {
+		t.Errorf("got cost %v, want none without a price table", env.Status.Cost)
+	}
+
+	r.PriceTable = testPriceTable
+	if err := r.updateCost(&phaseContext{env: env, provider: &stubMachineProvider{}}, time.Now()); err == nil {
+		t.Errorf("got no error for a missing price table")
+	}
+}
@@ -18,14 +18,16 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -36,10 +38,7 @@ import (
 
 	crossplaneruntime "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
 	computev1alpha1 "github.com/crossplane/crossplane/apis/compute/v1alpha1"
-	crossplanegcpv1alpha1 "github.com/crossplane/provider-gcp/apis/container/v1alpha1"
-	crossplanegcpv1beta1 "github.com/crossplane/provider-gcp/apis/container/v1beta1"
 	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
-	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -50,6 +49,19 @@ type EnvironmentReconciler struct {
 	Scheme              *runtime.Scheme
 	CrossplaneNamespace string
 	ArgoCDNamespace     string
+	Recorder            record.EventRecorder
+
+	// TTLWarnings are the times before the TTL expires a warning is sent, unless the environment sets its own
+	TTLWarnings []time.Duration
+	// SMTP is the server email notifications are sent through
+	SMTP SMTPConfig
+	// ActivitySignals replace the signals idle policies are evaluated with, e.g., with fakes
+	ActivitySignals []ActivitySignal
+	// PriceTable is the ConfigMap the cost of environments is estimated with, environments aren't priced without one
+	PriceTable types.NamespacedName
+
+	// prometheus keeps the results of the prometheus queries of idle policies between reconciles
+	prometheus *prometheusActivity
 }
 
 const (
@@ -58,10 +70,25 @@ const (
 	ClusterClaimFinalizer = "dev-environment/finalizers.clusterclaim.vadasambar.github.io"
 	GCPNodePoolFinalizer  = "dev-environment/finalizers.gcpnodepool.vadasambar.github.io"
 	EnvironmentFinalizer  = "dev-environment/finalizers.environment.vadasambar.github.io"
+
+	// EnvironmentNameLabel is added to the objects the controller creates for an environment
+	EnvironmentNameLabel = "dev.vadasambar.github.io/environment"
+	// EnvironmentUIDLabel holds the UID of the environment an object was created for, it tells
+	// objects of a deleted environment apart from the ones of a new environment with the same name
+	EnvironmentUIDLabel = "dev.vadasambar.github.io/environment-uid"
+	// ApplicationNameAnnotation holds the spec name of the application an argocd application was created for
+	ApplicationNameAnnotation = "dev.vadasambar.github.io/application"
+	// ExtendTTLAnnotation extends the TTL of an environment by its value (e.g., 4h), the controller removes it once applied
+	ExtendTTLAnnotation = "dev.vadasambar.github.io/extend-ttl"
 )
 
 // +kubebuilder:rbac:groups=dev.vadasambar.github.io,resources=environments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=dev.vadasambar.github.io,resources=environments/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=create;update
+// +kubebuilder:rbac:groups=dev.vadasambar.github.io,resources=nodepoolprofiles;environmenttemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;patch
 
 func (r *EnvironmentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	_ = context.Background()
@@ -77,168 +104,201 @@ func (r *EnvironmentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	}
 	r.Log.Info("environment object", "env", env)
 
-	if env.Spec.TTL != "" && r.areArgoCDAppDependenciesReady(env) && r.isClusterBound(env) && r.isArgoCDAppReady(env.Spec.Source.Name) {
-		if env.Status.TTLStartTimestamp.IsZero() {
-			now := metav1.Now()
-			env.Status.TTLStartTimestamp = &now
-			ttlTimeStampUpdationErr := r.Status().Update(context.Background(), env)
-			if ttlTimeStampUpdationErr != nil {
-				r.Log.Error(ttlTimeStampUpdationErr, "could not update ttlStartTimestamp", "env", env)
-				return ctrl.Result{Requeue: true}, ttlTimeStampUpdationErr
-			}
-		} else {
-			var ttl time.Duration
-			ttlDurationStr := env.Spec.TTL[:len(env.Spec.TTL)-1]
-			ttlDuration, _ := strconv.Atoi(ttlDurationStr)
-			ttlUnit := env.Spec.TTL[len(env.Spec.TTL)-1 : len(env.Spec.TTL)]
-			switch ttlUnit {
-			case "m":
-				ttl = time.Duration(ttlDuration) * time.Minute
-			case "h":
-				ttl = time.Duration(ttlDuration) * time.Hour
-			case "d":
-				ttl = time.Duration(ttlDuration) * time.Hour * 24
-			case "y":
-				ttl = time.Duration(ttlDuration) * time.Hour * 24 * 365
-			}
-
-			if time.Now().UTC().After(env.Status.TTLStartTimestamp.Add(ttl)) {
-				r.Log.Info(fmt.Sprintf("cluster '%s' exceeded TTL of %s (%s - %s)", env.Spec.ClusterName, env.Spec.TTL, env.Status.TTLStartTimestamp, metav1.Now()))
-				r.Log.Info("deleting the cluster")
-				deleteErr := r.Delete(context.Background(), env)
-				if deleteErr != nil && !kerrors.IsNotFound(deleteErr) {
-					r.Log.Error(deleteErr, "could not delete the environment even after exceeding TTL")
-					return ctrl.Result{Requeue: true}, deleteErr
-				}
-
-			}
-
+	if env.ObjectMeta.DeletionTimestamp.IsZero() {
+		if err := r.renderTemplate(env); err != nil {
+			r.Log.Error(err, "could not render the environment from its template")
+			return r.updateFailedStatus(env, err)
 		}
-
 	}
 
-	k8class, fetchClassErr := r.fetchClusterClass(env)
-	if fetchClassErr != nil {
-		r.Log.Error(fetchClassErr, "could not get cluster class referenced in the environment", "cluster-class",
-			env.Spec.ClusterClassLabel,
-			"namespace", r.CrossplaneNamespace)
-		return ctrl.Result{Requeue: true}, fetchClassErr
+	provider, providerErr := r.clusterProvider(env)
+	if providerErr != nil {
+		r.Log.Error(providerErr, "could not select cluster provider for the environment", "provider", env.Spec.Provider)
+		return r.updateFailedStatus(env, providerErr)
 	}
 
-	createdk8Cluster := &computev1alpha1.KubernetesCluster{}
-	createdk8ClusterNamespacedName := types.NamespacedName{
-		Name:      env.Spec.ClusterName,
-		Namespace: r.CrossplaneNamespace,
+	pc := &phaseContext{
+		env:      env,
+		provider: provider,
 	}
 
-	getClusterErr := r.Client.Get(context.Background(), createdk8ClusterNamespacedName, createdk8Cluster)
-	if getClusterErr != nil && kerrors.IsNotFound(getClusterErr) {
-		var createClusterErr error
-		createdk8Cluster, createClusterErr = r.createClusterClaim(env)
-
-		if createClusterErr != nil {
-			r.Log.Error(createClusterErr, "could not get created kubernetes clusterclaim", "cluster-class", k8class.GetName())
-			return ctrl.Result{Requeue: true}, createClusterErr
+	if !env.ObjectMeta.DeletionTimestamp.IsZero() {
+		phase, err := r.runPhases(pc, devv1alpha1.PhaseDeleting)
+		if err != nil {
+			return ctrl.Result{Requeue: true}, err
 		}
+		return r.updateStatus(pc, phase)
 	}
 
-	if fetchErr := r.fetchApp(env.Spec.Source.Name); fetchErr != nil && kerrors.IsNotFound(fetchErr) {
-		r.Log.Info("creating argocd source application", "source", env.Spec.Source.Name)
-		app, createAppErr := r.createArgoCDApp(env, r.getSourceApp(env))
-		if createAppErr != nil {
-			return ctrl.Result{Requeue: true}, createAppErr
-		}
-		r.Log.Info("created argocd source application", "source", env.Spec.Source.Name, "application", app)
+	if err := r.addFinalizers(pc); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
 
+	phase, err := r.runPhases(pc, devv1alpha1.PhasePending)
+	if err != nil {
+		return ctrl.Result{Requeue: true}, err
 	}
 
-	for _, dependency := range env.Spec.Dependencies {
-		if fetchErr := r.fetchApp(dependency.Name); fetchErr != nil && kerrors.IsNotFound(fetchErr) {
-			r.Log.Info("creating argocd dependency application", "dependency", dependency.Name)
-			app, createAppErr := r.createArgoCDApp(env, r.getDependencyApp(&dependency, env.Spec.ClusterName))
-			if createAppErr != nil {
-				return ctrl.Result{Requeue: true}, createAppErr
-			}
-			r.Log.Info("created argocd dependency application", "dependency", dependency.Name, "application", app)
+	return r.updateStatus(pc, phase)
+}
 
+// updateStatus records the phase the environment stopped in along with its conditions
+func (r *EnvironmentReconciler) updateStatus(pc *phaseContext, phase devv1alpha1.EnvironmentPhase) (ctrl.Result, error) {
+	env := pc.env
+	env.Status.Phase = phase
+	env.Status.Ready = phase == devv1alpha1.PhaseReady || phase == devv1alpha1.PhaseExpiring
+	// the TTL keeps running while the environment sleeps
+	if !env.Status.Ready && phase != devv1alpha1.PhaseSleeping {
+		env.Status.TTLStartTimestamp = nil
+		env.Status.TTLSuspendedTimestamp = nil
+		env.Status.ExpiresAt = nil
+		env.Status.TTLExtension = nil
+	}
+
+	if phase == devv1alpha1.PhaseDeleting {
+		// the environment might be gone already
+		if err := r.Status().Update(context.Background(), env); err != nil && !kerrors.IsNotFound(err) {
+			r.Log.Error(err, "could not update `Status` of env", "object", env)
+		}
+		if env.Status.Teardown != nil {
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 		}
+		return ctrl.Result{}, nil
 	}
 
-	var managedResourceName string
-	if createdk8Cluster.Spec.ResourceReference != nil {
-		managedResourceName = createdk8Cluster.Spec.ResourceReference.Name
-	} else {
-		return ctrl.Result{Requeue: true}, nil
+	r.setConditions(env, pc.provider)
+	if pc.failure != nil {
+		setFailedCondition(env, pc.failure)
 	}
 
-	gkeNodepool := &crossplanegcpv1alpha1.NodePool{}
-
-	gkeNodepoolNamespacedName := types.NamespacedName{
-		Name: env.Spec.ClusterName,
-	}
-	getNodepoolErr := r.Client.Get(context.Background(), gkeNodepoolNamespacedName, gkeNodepool)
-	if getNodepoolErr != nil && kerrors.IsNotFound(getNodepoolErr) {
-		r.Log.Info("creating nodepool")
-		createNodepoolErr := r.createNodePools(env, k8class, managedResourceName)
-		if createNodepoolErr != nil {
-			r.Log.Error(createNodepoolErr, "could not create nodepool for the cluster", "nodepool name", env.Spec.ClusterName, "cluster name", env.Spec.ClusterName)
-			return ctrl.Result{Requeue: true}, createNodepoolErr
-		}
-		r.Log.Info("created nodepool")
+	r.Log.Info("status before updating", "env.Status", env.Status)
+	if err := r.Status().Update(context.Background(), env); err != nil {
+		r.Log.Error(err, "could not update `Status` of env", "object", env)
+		return ctrl.Result{RequeueAfter: time.Second * 10}, err
 	}
 
-	return r.updateStatus(env)
+	return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 }
 
-func (r *EnvironmentReconciler) updateStatus(env *devv1alpha1.Environment) (ctrl.Result, error) {
-
-	if r.isClusterBound(env) && r.isArgoCDAppReady(env.Spec.Source.Name) && r.areArgoCDAppDependenciesReady(env) {
-		env.Status.Ready = true
-		if err := r.Status().Update(context.Background(), env); err != nil {
-			r.Log.Error(err, "could not update `Status` of env", "object", env)
-			return ctrl.Result{RequeueAfter: time.Second * 10}, err
-		}
-		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
-	}
-
+// updateFailedStatus records a failure that happened before the phases could run
+func (r *EnvironmentReconciler) updateFailedStatus(env *devv1alpha1.Environment, failure error) (ctrl.Result, error) {
+	env.Status.Phase = devv1alpha1.PhaseFailed
 	env.Status.Ready = false
-	env.Status.TTLStartTimestamp = nil
-	r.Log.Info("status before updating", "env.Status.TTLStartTimestamp", env.Status)
+	setFailedCondition(env, failure)
 	if err := r.Status().Update(context.Background(), env); err != nil {
 		r.Log.Error(err, "could not update `Status` of env", "object", env)
+		return ctrl.Result{Requeue: true}, err
 	}
 
 	return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 }
 
+// setFailedCondition marks the environment as not ready because of an error that needs a change of the spec
+func setFailedCondition(env *devv1alpha1.Environment, failure error) {
+	devv1alpha1.SetCondition(&env.Status.Conditions, devv1alpha1.Condition{
+		Type:               devv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: env.GetGeneration(),
+		Reason:             "Failed",
+		Message:            failure.Error(),
+	})
+}
+
 func (r *EnvironmentReconciler) areArgoCDAppDependenciesReady(env *devv1alpha1.Environment) bool {
-	argocdDependenciesReady := true
+	return allApplicationsReady(r.dependencyStatuses(env))
+}
+
+func (r *EnvironmentReconciler) areArgoCDAppSourcesReady(env *devv1alpha1.Environment) bool {
+	return allApplicationsReady(r.sourceStatuses(env))
+}
+
+// sourceStatuses observes the argocd application of each source
+func (r *EnvironmentReconciler) sourceStatuses(env *devv1alpha1.Environment) []devv1alpha1.ApplicationStatus {
+	statuses := []devv1alpha1.ApplicationStatus{}
+	for _, source := range env.Spec.SourceApplications() {
+		statuses = append(statuses, r.applicationStatus(env, source.Name))
+	}
+
+	return statuses
+}
+
+// dependencyStatuses observes the argocd application of each dependency
+func (r *EnvironmentReconciler) dependencyStatuses(env *devv1alpha1.Environment) []devv1alpha1.ApplicationStatus {
+	statuses := []devv1alpha1.ApplicationStatus{}
 	for _, dependency := range env.Spec.Dependencies {
-		if argocdDependenciesReady == false {
-			return argocdDependenciesReady
-		}
+		statuses = append(statuses, r.applicationStatus(env, dependency.Name))
+	}
+
+	return statuses
+}
+
+// applicationStatus observes the argocd application deployed for an application of the spec
+func (r *EnvironmentReconciler) applicationStatus(env *devv1alpha1.Environment, name string) devv1alpha1.ApplicationStatus {
+	status := devv1alpha1.ApplicationStatus{
+		Name: name,
+	}
+
+	argocdApp, err := r.fetchApp(env, name)
+	if kerrors.IsNotFound(err) {
+		status.Message = "argocd application hasn't been created yet"
+		return status
+	}
+	if err != nil {
+		status.Message = fmt.Sprintf("could not get argocd application: %v", err)
+		return status
+	}
 
-		if r.isArgoCDAppReady(dependency.Name) {
-			argocdDependenciesReady = argocdDependenciesReady && true
+	status.ApplicationName = argocdApp.GetName()
+	status.SyncStatus = string(argocdApp.Status.Sync.Status)
+	status.HealthStatus = argocdApp.Status.Health.Status
+	status.Revision = argocdApp.Status.Sync.Revision
+	status.Message = lastApplicationError(argocdApp)
+	status.Ready = argocdApp.Status.Health.Status == argocdapplicationv1alpha1.HealthStatusHealthy &&
+		argocdApp.Status.Sync.Status == argocdapplicationv1alpha1.SyncStatusCodeSynced
+
+	return status
+}
+
+// lastApplicationError returns the message of a failed sync, an error condition or an unhealthy resource
+func lastApplicationError(argocdApp *argocdapplicationv1alpha1.Application) string {
+	if operationState := argocdApp.Status.OperationState; operationState != nil &&
+		(operationState.Phase == argocdapplicationv1alpha1.OperationFailed || operationState.Phase == argocdapplicationv1alpha1.OperationError) {
+		return operationState.Message
+	}
+
+	for i := range argocdApp.Status.Conditions {
+		if argocdApp.Status.Conditions[i].IsError() {
+			return argocdApp.Status.Conditions[i].Message
 		}
 	}
 
-	return argocdDependenciesReady
+	return argocdApp.Status.Health.Message
 }
 
-func (r *EnvironmentReconciler) isEverythingReady(env *devv1alpha1.Environment) bool {
-	argocdDependenciesReady := true
-	for _, dependency := range env.Spec.Dependencies {
-		if argocdDependenciesReady == false {
-			break
+func allApplicationsReady(statuses []devv1alpha1.ApplicationStatus) bool {
+	return len(notReadyApplications(statuses)) == 0
+}
+
+// notReadyApplications describes the applications that aren't ready
+func notReadyApplications(statuses []devv1alpha1.ApplicationStatus) []string {
+	notReady := []string{}
+	for _, status := range statuses {
+		if status.Ready {
+			continue
 		}
 
-		if r.isArgoCDAppReady(dependency.Name) {
-			argocdDependenciesReady = argocdDependenciesReady && true
+		if status.SyncStatus == "" && status.HealthStatus == "" {
+			notReady = append(notReady, fmt.Sprintf("%s (%s)", status.Name, status.Message))
+			continue
 		}
+		notReady = append(notReady, fmt.Sprintf("%s (%s/%s)", status.Name, status.SyncStatus, status.HealthStatus))
 	}
 
-	if r.isClusterBound(env) && r.isArgoCDAppReady(env.Spec.Source.Name) && argocdDependenciesReady {
+	return notReady
+}
+
+func (r *EnvironmentReconciler) isEverythingReady(env *devv1alpha1.Environment, provider ClusterProvider) bool {
+	if provider.IsClusterReady(env) && r.areArgoCDAppSourcesReady(env) && r.areArgoCDAppDependenciesReady(env) {
 		return true
 	}
 
@@ -262,10 +322,9 @@ func (r *EnvironmentReconciler) isClusterBound(env *devv1alpha1.Environment) boo
 	return false
 }
 
-func (r *EnvironmentReconciler) isArgoCDAppReady(name string) bool {
-	argocdApp := &argocdapplicationv1alpha1.Application{}
-	var err error
-	if err = r.Client.Get(context.Background(), types.NamespacedName{Name: name, Namespace: r.ArgoCDNamespace}, argocdApp); err == nil {
+func (r *EnvironmentReconciler) isArgoCDAppReady(env *devv1alpha1.Environment, name string) bool {
+	argocdApp, err := r.fetchApp(env, name)
+	if err == nil {
 
 		if argocdApp.Status.Health.Status == argocdapplicationv1alpha1.HealthStatusHealthy &&
 			argocdApp.Status.Sync.Status == argocdapplicationv1alpha1.SyncStatusCodeSynced {
@@ -278,36 +337,72 @@ func (r *EnvironmentReconciler) isArgoCDAppReady(name string) bool {
 
 	}
 
-	r.Log.Error(err, "could not get argocdApp", "NamespacedName", types.NamespacedName{Name: name, Namespace: r.ArgoCDNamespace}, "dependency", argocdApp)
+	r.Log.Error(err, "could not get argocdApp", "name", name, "namespace", r.ArgoCDNamespace)
 	return false
 }
 
-func (r *EnvironmentReconciler) fetchApp(name string) error {
+// fetchApp returns the argocd application owned by the environment for an application of the spec
+func (r *EnvironmentReconciler) fetchApp(env *devv1alpha1.Environment, name string) (*argocdapplicationv1alpha1.Application, error) {
+	argoCDApplications := &argocdapplicationv1alpha1.ApplicationList{}
+	if err := r.Client.List(context.Background(), argoCDApplications,
+		client.InNamespace(r.ArgoCDNamespace),
+		client.MatchingLabels{EnvironmentNameLabel: env.GetName()}); err != nil {
+		return nil, err
+	}
+
+	for i := range argoCDApplications.Items {
+		argoCDApplication := &argoCDApplications.Items[i]
+		if argoCDApplication.GetAnnotations()[ApplicationNameAnnotation] == name && metav1.IsControlledBy(argoCDApplication, env) {
+			return argoCDApplication, nil
+		}
+	}
+
+	return r.adoptLegacyApp(env, name)
+}
+
+// adoptLegacyApp migrates an application created before application names were scoped to the environment.
+// Those were named after the spec and had no labels, so they are looked up by name and
+// adopted when the environment owns them. Adopted applications keep their name.
+func (r *EnvironmentReconciler) adoptLegacyApp(env *devv1alpha1.Environment, name string) (*argocdapplicationv1alpha1.Application, error) {
 	argoCDApplication := &argocdapplicationv1alpha1.Application{}
 	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: r.ArgoCDNamespace, Name: name}, argoCDApplication); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-}
+	if !metav1.IsControlledBy(argoCDApplication, env) {
+		return nil, kerrors.NewNotFound(argocdapplicationv1alpha1.Resource("applications"), name)
+	}
 
-func (r *EnvironmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&devv1alpha1.Environment{}).
-		Complete(r)
-}
+	r.Log.Info("adopting argocd application created before application names were scoped", "application", name)
+	labels := argoCDApplication.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range environmentLabels(env) {
+		labels[key] = value
+	}
+	argoCDApplication.SetLabels(labels)
 
-func (r *EnvironmentReconciler) fetchClusterClass(env *devv1alpha1.Environment) (*crossplanegcpv1beta1.GKEClusterClass, error) {
-	k8class := &crossplanegcpv1beta1.GKEClusterClass{}
-	k8classNamespacedName := types.NamespacedName{
-		Name: env.Spec.ClusterClassLabel,
+	annotations := argoCDApplication.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
 	}
-	err := r.Client.Get(context.Background(), k8classNamespacedName, k8class)
-	if err != nil {
+	annotations[ApplicationNameAnnotation] = name
+	argoCDApplication.SetAnnotations(annotations)
+
+	if err := r.Client.Update(context.Background(), argoCDApplication); err != nil {
+		r.Log.Error(err, "could not adopt argocd application", "application", name)
 		return nil, err
 	}
 
-	return k8class, nil
+	return argoCDApplication, nil
+}
+
+func (r *EnvironmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.prometheus = newPrometheusActivity(prometheusQueryInterval)
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&devv1alpha1.Environment{}).
+		Complete(r)
 }
 
 func (r *EnvironmentReconciler) createArgoCDApp(env *devv1alpha1.Environment, argocdApplication *argocdapplicationv1alpha1.Application) (*argocdapplicationv1alpha1.Application, error) {
@@ -336,23 +431,55 @@ func (r *EnvironmentReconciler) createArgoCDApp(env *devv1alpha1.Environment, ar
 	return createdArgoCDApp, nil
 }
 
-func (r *EnvironmentReconciler) getSourceApp(env *devv1alpha1.Environment) *argocdapplicationv1alpha1.Application {
-	argocdApplication := &argocdapplicationv1alpha1.Application{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      env.Spec.Source.Name,
-			Namespace: r.ArgoCDNamespace,
+// applicationDestination returns where argocd deploys an application of the environment.
+// Namespace isolated environments deploy everything to their namespace in the host cluster.
+func (r *EnvironmentReconciler) applicationDestination(env *devv1alpha1.Environment, namespace string) argocdapplicationv1alpha1.ApplicationDestination {
+	if env.Spec.Isolation == devv1alpha1.IsolationNamespace {
+		return argocdapplicationv1alpha1.ApplicationDestination{
+			Namespace: env.Spec.ClusterName,
+			Server:    HostClusterServer,
+		}
+	}
+
+	return argocdapplicationv1alpha1.ApplicationDestination{
+		Namespace: namespace,
+		Name:      env.Spec.ClusterName,
+	}
+}
+
+// applicationObjectMeta returns the metadata of the argocd application deployed for an application of the spec
+func (r *EnvironmentReconciler) applicationObjectMeta(env *devv1alpha1.Environment, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      env.ApplicationName(name),
+		Namespace: r.ArgoCDNamespace,
+		Labels:    environmentLabels(env),
+		Annotations: map[string]string{
+			ApplicationNameAnnotation: name,
 		},
+	}
+}
+
+func (r *EnvironmentReconciler) getSourceApp(source *devv1alpha1.AppSrc, env *devv1alpha1.Environment) (*argocdapplicationv1alpha1.Application, error) {
+	helmSource, helmErr := r.helmSource(source.Helm, helmReleaseName(source.Name, source.ReleaseName, source.Helm, source.ChartName))
+	if helmErr != nil {
+		r.Log.Error(helmErr, "could not render helm values of the source", "source", source.Name)
+		return nil, helmErr
+	}
+
+	argocdApplication := &argocdapplicationv1alpha1.Application{
+		ObjectMeta: r.applicationObjectMeta(env, source.Name),
 		Spec: argocdapplicationv1alpha1.ApplicationSpec{
 			Source: argocdapplicationv1alpha1.ApplicationSource{
-				RepoURL:        env.Spec.Source.RepoURL,
-				Path:           env.Spec.Source.Path,
-				TargetRevision: env.Spec.Source.Revision,
+				RepoURL:        source.RepoURL,
+				Path:           source.Path,
+				TargetRevision: source.Revision,
+				Chart:          source.ChartName,
+				Helm:           helmSource,
+				Kustomize:      kustomizeSource(source.Kustomize),
+				Directory:      directorySource(source.Directory),
 			},
-			Destination: argocdapplicationv1alpha1.ApplicationDestination{
-				Namespace: env.Spec.Source.Namespace,
-				Name:      env.Spec.ClusterName,
-			},
-			Project: "default",
+			Destination: r.applicationDestination(env, source.Namespace),
+			Project:     "default",
 			SyncPolicy: &argocdapplicationv1alpha1.SyncPolicy{
 				Automated: &argocdapplicationv1alpha1.SyncPolicyAutomated{
 					Prune:    true,
@@ -361,26 +488,30 @@ func (r *EnvironmentReconciler) getSourceApp(env *devv1alpha1.Environment) *argo
 			},
 		},
 	}
-	return argocdApplication
+	applyImageOverrides(&argocdApplication.Spec.Source, source.ImageOverrides)
+	return argocdApplication, nil
 }
 
-func (r *EnvironmentReconciler) getDependencyApp(dependency *devv1alpha1.DependencySrc, clusterName string) *argocdapplicationv1alpha1.Application {
+func (r *EnvironmentReconciler) getDependencyApp(dependency *devv1alpha1.DependencySrc, env *devv1alpha1.Environment) (*argocdapplicationv1alpha1.Application, error) {
+	helmSource, helmErr := r.helmSource(dependency.Helm, helmReleaseName(dependency.Name, dependency.ReleaseName, dependency.Helm, dependency.ChartName))
+	if helmErr != nil {
+		r.Log.Error(helmErr, "could not render helm values of the dependency", "dependency", dependency.Name)
+		return nil, helmErr
+	}
+
 	argocdApplication := &argocdapplicationv1alpha1.Application{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      dependency.Name,
-			Namespace: r.ArgoCDNamespace,
-		},
+		ObjectMeta: r.applicationObjectMeta(env, dependency.Name),
 		Spec: argocdapplicationv1alpha1.ApplicationSpec{
 			Source: argocdapplicationv1alpha1.ApplicationSource{
 				RepoURL:        dependency.RepoURL,
 				Chart:          dependency.ChartName,
 				TargetRevision: dependency.Revision,
+				Helm:           helmSource,
+				Kustomize:      kustomizeSource(dependency.Kustomize),
+				Directory:      directorySource(dependency.Directory),
 			},
-			Destination: argocdapplicationv1alpha1.ApplicationDestination{
-				Namespace: dependency.Namespace,
-				Name:      clusterName,
-			},
-			Project: "default",
+			Destination: r.applicationDestination(env, dependency.Namespace),
+			Project:     "default",
 			SyncPolicy: &argocdapplicationv1alpha1.SyncPolicy{
 				Automated: &argocdapplicationv1alpha1.SyncPolicyAutomated{
 					Prune:    true,
@@ -389,7 +520,78 @@ func (r *EnvironmentReconciler) getDependencyApp(dependency *devv1alpha1.Depende
 			},
 		},
 	}
-	return argocdApplication
+	return argocdApplication, nil
+}
+
+// ensureClusterClaim creates the KubernetesCluster claim if it doesn't exist, patches it when
+// it drifted from the environment and returns the name of the managed cluster it is bound to
+func (r *EnvironmentReconciler) ensureClusterClaim(env *devv1alpha1.Environment) (string, error) {
+	createdk8Cluster := &computev1alpha1.KubernetesCluster{}
+	createdk8ClusterNamespacedName := types.NamespacedName{
+		Name:      env.Spec.ClusterName,
+		Namespace: r.CrossplaneNamespace,
+	}
+
+	getClusterErr := r.Client.Get(context.Background(), createdk8ClusterNamespacedName, createdk8Cluster)
+	if getClusterErr != nil && !kerrors.IsNotFound(getClusterErr) {
+		return "", getClusterErr
+	}
+
+	if getClusterErr != nil {
+		var createClusterErr error
+		createdk8Cluster, createClusterErr = r.createClusterClaim(env)
+		if createClusterErr != nil {
+			return "", createClusterErr
+		}
+	} else if err := r.patchClusterClaim(env, createdk8Cluster); err != nil {
+		return "", err
+	}
+
+	if createdk8Cluster.Spec.ResourceReference == nil {
+		return "", nil
+	}
+
+	return createdk8Cluster.Spec.ResourceReference.Name, nil
+}
+
+// clusterClaimSpec returns the spec of the KubernetesCluster claim of the environment
+func clusterClaimSpec(env *devv1alpha1.Environment) computev1alpha1.KubernetesClusterSpec {
+	return computev1alpha1.KubernetesClusterSpec{
+		ResourceClaimSpec: crossplaneruntime.ResourceClaimSpec{
+			ClassSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					ClassNameLabel: env.Spec.ClusterClassLabel,
+				},
+			},
+			WriteConnectionSecretToReference: &crossplaneruntime.LocalSecretReference{
+				Name: env.Spec.ClusterName,
+			},
+		},
+	}
+}
+
+// patchClusterClaim corrects changes of the claim made outside the controller.
+// The class selector is left alone once the claim is bound, crossplane ignores it from then on.
+func (r *EnvironmentReconciler) patchClusterClaim(env *devv1alpha1.Environment, claim *computev1alpha1.KubernetesCluster) error {
+	desired := clusterClaimSpec(env)
+	selectorDrifted := claim.Spec.ResourceReference == nil && !equality.Semantic.DeepEqual(desired.ClassSelector, claim.Spec.ClassSelector)
+	secretDrifted := !equality.Semantic.DeepEqual(desired.WriteConnectionSecretToReference, claim.Spec.WriteConnectionSecretToReference)
+	if !selectorDrifted && !secretDrifted {
+		return nil
+	}
+
+	r.Log.Info("kubernetes cluster claim drifted from the environment, patching it", "cluster-name", env.Spec.ClusterName)
+	patch := client.MergeFrom(claim.DeepCopy())
+	if selectorDrifted {
+		claim.Spec.ClassSelector = desired.ClassSelector
+	}
+	claim.Spec.WriteConnectionSecretToReference = desired.WriteConnectionSecretToReference
+	if err := r.Client.Patch(context.Background(), claim, patch); err != nil {
+		r.Log.Error(err, "could not patch the cluster claim", "cluster claim", claim.GetName(), "namespace", claim.GetNamespace())
+		return err
+	}
+
+	return nil
 }
 
 func (r *EnvironmentReconciler) createClusterClaim(env *devv1alpha1.Environment) (*computev1alpha1.KubernetesCluster, error) {
@@ -398,22 +600,12 @@ func (r *EnvironmentReconciler) createClusterClaim(env *devv1alpha1.Environment)
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      env.Spec.ClusterName,
 			Namespace: r.CrossplaneNamespace,
+			Labels:    environmentLabels(env),
 			Annotations: map[string]string{
 				crossplanemetav1.ExternalNameAnnotationKey: env.Spec.ClusterName,
 			},
 		},
-		Spec: computev1alpha1.KubernetesClusterSpec{
-			ResourceClaimSpec: crossplaneruntime.ResourceClaimSpec{
-				ClassSelector: &metav1.LabelSelector{
-					MatchLabels: map[string]string{
-						ClassNameLabel: env.Spec.ClusterClassLabel,
-					},
-				},
-				WriteConnectionSecretToReference: &crossplaneruntime.LocalSecretReference{
-					Name: env.Spec.ClusterName,
-				},
-			},
-		},
+		Spec: clusterClaimSpec(env),
 	}
 
 	if err := ctrl.SetControllerReference(env, newk8cluster, r.Scheme); err != nil {
@@ -440,45 +632,138 @@ func (r *EnvironmentReconciler) createClusterClaim(env *devv1alpha1.Environment)
 	return createdk8Cluster, nil
 }
 
-func (r *EnvironmentReconciler) createNodePools(env *devv1alpha1.Environment, k8class *crossplanegcpv1beta1.GKEClusterClass, managedResourceName string) error {
+// environmentLabels returns the labels of the objects the controller creates for the environment
+func environmentLabels(env *devv1alpha1.Environment) map[string]string {
+	return map[string]string{
+		EnvironmentNameLabel: env.GetName(),
+		EnvironmentUIDLabel:  string(env.GetUID()),
+	}
+}
 
-	// Note: Nodepools should be a part of cluster class but it hasn't been integrated with cluster class yet
-	initialNodeCount := int64(2)
-	nodePool := &crossplanegcpv1alpha1.NodePool{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      env.Spec.ClusterName,
-			Namespace: r.CrossplaneNamespace,
-		},
-		Spec: crossplanegcpv1alpha1.NodePoolSpec{
-			ResourceSpec: crossplaneruntime.ResourceSpec{
-				ProviderReference: &corev1.ObjectReference{
-					Name: k8class.SpecTemplate.ProviderReference.Name,
-				},
-				WriteConnectionSecretToReference: &crossplaneruntime.SecretReference{
-					Name:      fmt.Sprintf("%s-nodepool", env.Spec.ClusterName),
-					Namespace: r.CrossplaneNamespace,
-				},
-			},
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
 
-			ForProvider: crossplanegcpv1alpha1.NodePoolParameters{
-				ClusterRef: &crossplanegcpv1alpha1.GKEClusterURIReferencerForNodePool{
-					GKEClusterURIReferencer: crossplanegcpv1beta1.GKEClusterURIReferencer{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: managedResourceName,
-						},
-					},
-				},
-				InitialNodeCount: &initialNodeCount,
-			},
-		},
+func removeString(slice []string, s string) []string {
+	result := []string{}
+	for _, item := range slice {
+		if item == s {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// patchArgoCDApp patches the fields of the argocd application the controller manages when they differ from the desired application.
+// It returns whether the application was patched.
+func (r *EnvironmentReconciler) patchArgoCDApp(argocdApp, desiredApp *argocdapplicationv1alpha1.Application) (bool, error) {
+	desiredSpec, normalizeErr := normalizeApplicationSpec(desiredApp.Spec)
+	if normalizeErr != nil {
+		return false, normalizeErr
+	}
+	liveSpec, normalizeErr := normalizeApplicationSpec(argocdApp.Spec)
+	if normalizeErr != nil {
+		return false, normalizeErr
+	}
+
+	if equality.Semantic.DeepEqual(desiredSpec.Source, liveSpec.Source) &&
+		equality.Semantic.DeepEqual(desiredSpec.Destination, liveSpec.Destination) &&
+		desiredSpec.Project == liveSpec.Project &&
+		equality.Semantic.DeepEqual(desiredSpec.SyncPolicy, liveSpec.SyncPolicy) {
+		return false, nil
+	}
+
+	r.Log.Info("argocd application differs from the environment spec, patching it", "application", argocdApp.GetName())
+	patch := client.MergeFrom(argocdApp.DeepCopy())
+	argocdApp.Spec.Source = desiredApp.Spec.Source
+	argocdApp.Spec.Destination = desiredApp.Spec.Destination
+	argocdApp.Spec.Project = desiredApp.Spec.Project
+	argocdApp.Spec.SyncPolicy = desiredApp.Spec.SyncPolicy
+	if err := r.Client.Patch(context.Background(), argocdApp, patch); err != nil {
+		r.Log.Error(err, "could not patch argocd application", "application", argocdApp.GetName(), "namespace", r.ArgoCDNamespace)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// normalizeApplicationSpec round trips the spec through json, so empty and nil fields compare equal
+func normalizeApplicationSpec(spec argocdapplicationv1alpha1.ApplicationSpec) (*argocdapplicationv1alpha1.ApplicationSpec, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := &argocdapplicationv1alpha1.ApplicationSpec{}
+	if err := json.Unmarshal(data, normalized); err != nil {
+		return nil, err
 	}
-	if err := ctrl.SetControllerReference(env, nodePool, r.Scheme); err != nil {
-		r.Log.Error(err, "could not set owner reference on gke nodepool")
+
+	return normalized, nil
+}
+
+// pruneApps deletes the argocd applications of applications that were removed from the spec
+// along with the resources they deployed
+func (r *EnvironmentReconciler) pruneApps(env *devv1alpha1.Environment) error {
+	names := map[string]bool{}
+	for _, source := range env.Spec.SourceApplications() {
+		names[source.Name] = true
+	}
+	for _, dependency := range env.Spec.Dependencies {
+		names[dependency.Name] = true
+	}
+
+	argoCDApplications := &argocdapplicationv1alpha1.ApplicationList{}
+	if err := r.Client.List(context.Background(), argoCDApplications,
+		client.InNamespace(r.ArgoCDNamespace),
+		client.MatchingLabels{EnvironmentNameLabel: env.GetName()}); err != nil {
 		return err
 	}
 
-	if err := r.Client.Create(context.Background(), nodePool); err != nil {
+	for i := range argoCDApplications.Items {
+		argoCDApplication := &argoCDApplications.Items[i]
+		if names[argoCDApplication.GetAnnotations()[ApplicationNameAnnotation]] || !metav1.IsControlledBy(argoCDApplication, env) {
+			continue
+		}
+
+		r.Log.Info("pruning argocd application removed from the environment", "application", argoCDApplication.GetName())
+		if err := r.deleteArgoCDApp(argoCDApplication); err != nil {
+			return err
+		}
+	}
+
+	applications := []devv1alpha1.ApplicationRef{}
+	for _, ref := range env.Status.Applications {
+		if names[ref.Name] {
+			applications = append(applications, ref)
+		}
+	}
+	env.Status.Applications = applications
+
+	return nil
+}
+
+// deleteArgoCDApp deletes the argocd application along with the resources it deployed
+func (r *EnvironmentReconciler) deleteArgoCDApp(argoCDApplication *argocdapplicationv1alpha1.Application) error {
+	if !argoCDApplication.CascadedDeletion() {
+		patch := client.MergeFrom(argoCDApplication.DeepCopy())
+		argoCDApplication.SetCascadedDeletion(true)
+		if err := r.Client.Patch(context.Background(), argoCDApplication, patch); err != nil {
+			r.Log.Error(err, "could not enable cascaded deletion of argocd application", "application", argoCDApplication.GetName())
+			return err
+		}
+	}
+
+	if err := r.Client.Delete(context.Background(), argoCDApplication); err != nil && !kerrors.IsNotFound(err) {
+		r.Log.Error(err, "could not delete argocd application", "application", argoCDApplication.GetName())
 		return err
 	}
+
 	return nil
 }
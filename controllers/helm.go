@@ -0,0 +1,179 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// helmSource maps the helm spec of an application onto the argocd application source.
+// ConfigMap and Secret references are resolved here, so they're read on every reconcile.
+func (r *EnvironmentReconciler) helmSource(helm *devv1alpha1.HelmSpec, releaseName string) (*argocdapplicationv1alpha1.ApplicationSourceHelm, error) {
+	if helm == nil && releaseName == "" {
+		return nil, nil
+	}
+
+	helmSource := &argocdapplicationv1alpha1.ApplicationSourceHelm{
+		ReleaseName: releaseName,
+	}
+	if helm == nil {
+		return helmSource, nil
+	}
+
+	values, valuesErr := r.helmValues(helm)
+	if valuesErr != nil {
+		return nil, valuesErr
+	}
+	helmSource.Values = values
+	helmSource.ValueFiles = helm.ValueFiles
+
+	for _, parameter := range helm.Parameters {
+		value := parameter.Value
+		if parameter.ValueFrom != nil {
+			var resolveErr error
+			value, resolveErr = r.resolveValue(parameter.ValueFrom)
+			if resolveErr != nil {
+				return nil, fmt.Errorf("could not resolve helm parameter '%s': %v", parameter.Name, resolveErr)
+			}
+		}
+
+		helmSource.Parameters = append(helmSource.Parameters, argocdapplicationv1alpha1.HelmParameter{
+			Name:        parameter.Name,
+			Value:       value,
+			ForceString: parameter.ForceString,
+		})
+	}
+
+	return helmSource, nil
+}
+
+// helmReleaseName returns the release name of a chart or helm source. It defaults to the name of the
+// application in the spec rather than the scoped argocd application name, so the resources of the release
+// keep names like `mysql`. Other sources have no release, argocd would render them with helm otherwise.
+func helmReleaseName(name, releaseName string, helm *devv1alpha1.HelmSpec, chartName string) string {
+	if releaseName == "" && (helm != nil || chartName != "") {
+		return name
+	}
+
+	return releaseName
+}
+
+// helmValues merges the values referenced by valuesFrom in order and the inline values on top
+func (r *EnvironmentReconciler) helmValues(helm *devv1alpha1.HelmSpec) (string, error) {
+	if len(helm.ValuesFrom) == 0 {
+		return helm.Values, nil
+	}
+
+	blocks := []string{}
+	for i := range helm.ValuesFrom {
+		block, resolveErr := r.resolveValue(&helm.ValuesFrom[i])
+		if resolveErr != nil {
+			return "", fmt.Errorf("could not resolve helm values: %v", resolveErr)
+		}
+		blocks = append(blocks, block)
+	}
+	blocks = append(blocks, helm.Values)
+
+	merged := map[string]interface{}{}
+	for _, block := range blocks {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(block), &values); err != nil {
+			return "", fmt.Errorf("could not parse helm values: %v", err)
+		}
+		mergeValues(merged, values)
+	}
+
+	if len(merged) == 0 {
+		return "", nil
+	}
+
+	out, err := yaml.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// mergeValues merges src into dst, nested maps are merged and everything else is replaced like helm does
+func mergeValues(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+
+		dst[key] = value
+	}
+}
+
+// resolveValue reads the ConfigMap or Secret key a value source references
+func (r *EnvironmentReconciler) resolveValue(source *devv1alpha1.ValueSource) (string, error) {
+	switch {
+	case source.ConfigMapKeyRef != nil:
+		selector := source.ConfigMapKeyRef
+		configMap := &corev1.ConfigMap{}
+		if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: selector.Namespace, Name: selector.Name}, configMap); err != nil {
+			if kerrors.IsNotFound(err) && selector.Optional {
+				return "", nil
+			}
+			return "", err
+		}
+
+		if value, ok := configMap.Data[selector.Key]; ok {
+			return value, nil
+		}
+		if value, ok := configMap.BinaryData[selector.Key]; ok {
+			return string(value), nil
+		}
+		if selector.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("key '%s' not found in configmap '%s/%s'", selector.Key, selector.Namespace, selector.Name)
+
+	case source.SecretKeyRef != nil:
+		selector := source.SecretKeyRef
+		secret := &corev1.Secret{}
+		if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: selector.Namespace, Name: selector.Name}, secret); err != nil {
+			if kerrors.IsNotFound(err) && selector.Optional {
+				return "", nil
+			}
+			return "", err
+		}
+
+		if value, ok := secret.Data[selector.Key]; ok {
+			return string(value), nil
+		}
+		if selector.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("key '%s' not found in secret '%s/%s'", selector.Key, selector.Namespace, selector.Name)
+	}
+
+	return "", fmt.Errorf("value source references neither a configmap nor a secret")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+func TestHelmReleaseName(t *testing.T) {
+	tests := []struct {
+		name        string
+		releaseName string
+		helm        *devv1alpha1.HelmSpec
+		chartName   string
+		want        string
+	}{
+		{
+			name:      "chart defaults to the name of the application",
+			chartName: "mysql",
+			want:      "mysql",
+		},
+		{
+			name: "helm source defaults to the name of the application",
+			helm: &devv1alpha1.HelmSpec{},
+			want: "mysql",
+		},
+		{
+			name:        "release name is kept",
+			releaseName: "db",
+			chartName:   "mysql",
+			want:        "db",
+		},
+		{
+			name: "other sources have no release",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := helmReleaseName("mysql", tt.releaseName, tt.helm, tt.chartName); got != tt.want {
+				t.Errorf("got release name %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
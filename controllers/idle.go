@@ -0,0 +1,304 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// LastActivityAnnotation is set by developers or their tooling to the time (RFC3339) they last used the environment, e.g.,
+// kubectl annotate --overwrite environment my-env dev.vadasambar.github.io/last-activity=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+const LastActivityAnnotation = "dev.vadasambar.github.io/last-activity"
+
+// prometheusQueryInterval is the time the result of the prometheus query of an environment is reused for,
+// environments are reconciled far more often than their idle policies need
+const prometheusQueryInterval = time.Minute
+
+// ActivitySignal reports when an environment was last used
+type ActivitySignal interface {
+	// LastActivity returns the last activity the signal observed, zero if it observed none
+	LastActivity(env *devv1alpha1.Environment) (time.Time, error)
+}
+
+// activitySignals returns the signals the idle policy is evaluated with
+func (r *EnvironmentReconciler) activitySignals() []ActivitySignal {
+	if r.ActivitySignals != nil {
+		return r.ActivitySignals
+	}
+
+	return []ActivitySignal{
+		argoCDActivity{r},
+		annotationActivity{},
+		r.prometheus,
+		scheduleActivity{},
+	}
+}
+
+// isIdle returns true if no signal reported activity within the idle policy of the environment
+// and records the last activity in the status
+func (r *EnvironmentReconciler) isIdle(env *devv1alpha1.Environment, now time.Time) (bool, error) {
+	policy := env.Spec.Idle
+	if policy == nil {
+		env.Status.LastActivity = nil
+		return false, nil
+	}
+
+	lastActivity := env.GetCreationTimestamp().Time
+	if env.Status.LastActivity != nil && env.Status.LastActivity.After(lastActivity) {
+		lastActivity = env.Status.LastActivity.Time
+	}
+	signals := r.activitySignals()
+	failed := 0
+	var signalErr error
+	for _, signal := range signals {
+		activity, err := signal.LastActivity(env)
+		if err != nil {
+			// the other signals still tell whether the environment is used
+			r.Log.Error(err, "could not read activity signal, skipping it", "signal", fmt.Sprintf("%T", signal))
+			failed++
+			signalErr = err
+			continue
+		}
+		if activity.After(lastActivity) {
+			lastActivity = activity
+		}
+	}
+	if len(signals) > 0 && failed == len(signals) {
+		return false, fmt.Errorf("could not read any activity signal: %v", signalErr)
+	}
+
+	last := metav1.NewTime(lastActivity)
+	env.Status.LastActivity = &last
+	return now.Sub(lastActivity) > policy.After.Duration, nil
+}
+
+// argoCDActivity reports the last sync or deployment of the argocd applications of the environment
+type argoCDActivity struct {
+	r *EnvironmentReconciler
+}
+
+func (s argoCDActivity) LastActivity(env *devv1alpha1.Environment) (time.Time, error) {
+	last := time.Time{}
+	for _, app := range s.r.environmentApps(env) {
+		if operation := app.Status.OperationState; operation != nil && operation.FinishedAt != nil && operation.FinishedAt.After(last) {
+			last = operation.FinishedAt.Time
+		}
+		for _, history := range app.Status.History {
+			if history.DeployedAt.After(last) {
+				last = history.DeployedAt.Time
+			}
+		}
+	}
+
+	return last, nil
+}
+
+// annotationActivity reports the time of the last-activity annotation
+type annotationActivity struct{}
+
+func (annotationActivity) LastActivity(env *devv1alpha1.Environment) (time.Time, error) {
+	value, ok := env.GetAnnotations()[LastActivityAnnotation]
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	last, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		// an invalid marker shouldn't keep the idle policy from working
+		return time.Time{}, nil
+	}
+
+	return last, nil
+}
+
+// prometheusActivity reports now while the prometheus query of the idle policy returns a value above zero.
+// Its results are reused for an interval, so prometheus isn't queried on every reconcile.
+type prometheusActivity struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	results map[types.UID]prometheusResult
+}
+
+// prometheusResult is the last result of the prometheus query of an environment
+type prometheusResult struct {
+	query     devv1alpha1.PrometheusSignal
+	queriedAt time.Time
+	activity  time.Time
+	err       error
+}
+
+func newPrometheusActivity(interval time.Duration) *prometheusActivity {
+	return &prometheusActivity{
+		interval: interval,
+		results:  map[types.UID]prometheusResult{},
+	}
+}
+
+// prometheusQueryResponse is the part of the prometheus instant query response the signal reads
+type prometheusQueryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+func (s *prometheusActivity) LastActivity(env *devv1alpha1.Environment) (time.Time, error) {
+	if env.Spec.Idle == nil || env.Spec.Idle.Prometheus == nil {
+		return time.Time{}, nil
+	}
+	if s == nil {
+		// the reconciler wasn't set up with a manager, query without caching
+		return queryPrometheusActivity(env.Spec.Idle.Prometheus)
+	}
+
+	now := time.Now()
+	query := *env.Spec.Idle.Prometheus
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if result, ok := s.results[env.GetUID()]; ok && result.query == query && now.Sub(result.queriedAt) < s.interval {
+		return result.activity, result.err
+	}
+
+	activity, err := queryPrometheusActivity(&query)
+	for uid, result := range s.results {
+		// environments that were deleted or don't query anymore
+		if now.Sub(result.queriedAt) >= s.interval {
+			delete(s.results, uid)
+		}
+	}
+	s.results[env.GetUID()] = prometheusResult{query: query, queriedAt: now, activity: activity, err: err}
+	return activity, err
+}
+
+// queryPrometheusActivity returns now if the query returns a value above zero
+func queryPrometheusActivity(prometheus *devv1alpha1.PrometheusSignal) (time.Time, error) {
+	client := &http.Client{Timeout: notificationTimeout}
+	resp, err := client.Get(fmt.Sprintf("%s/api/v1/query?query=%s", prometheus.URL, url.QueryEscape(prometheus.Query)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not query prometheus: %v", err)
+	}
+	defer resp.Body.Close()
+
+	response := prometheusQueryResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return time.Time{}, fmt.Errorf("could not parse prometheus response: %v", err)
+	}
+	if response.Status != "success" {
+		return time.Time{}, fmt.Errorf("prometheus query failed: %s", response.Error)
+	}
+
+	values, err := prometheusValues(response.Data.ResultType, response.Data.Result)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, value := range values {
+		if value > 0 {
+			return time.Now(), nil
+		}
+	}
+
+	return time.Time{}, nil
+}
+
+// prometheusValues returns the sample values of a scalar or vector result
+func prometheusValues(resultType string, result json.RawMessage) ([]float64, error) {
+	samples := [][]interface{}{}
+	switch resultType {
+	case "scalar":
+		sample := []interface{}{}
+		if err := json.Unmarshal(result, &sample); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	case "vector":
+		vector := []struct {
+			Value []interface{} `json:"value"`
+		}{}
+		if err := json.Unmarshal(result, &vector); err != nil {
+			return nil, err
+		}
+		for _, series := range vector {
+			samples = append(samples, series.Value)
+		}
+	default:
+		return nil, fmt.Errorf("prometheus query returned a %s, expected a scalar or a vector", resultType)
+	}
+
+	values := []float64{}
+	for _, sample := range samples {
+		// samples are [<unix time>, "<value>"]
+		if len(sample) != 2 {
+			continue
+		}
+		raw, ok := sample[1].(string)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// scheduleActivity reports the last time the schedule of the environment woke it up,
+// so the time it slept doesn't count as idle
+type scheduleActivity struct{}
+
+func (scheduleActivity) LastActivity(env *devv1alpha1.Environment) (time.Time, error) {
+	schedule := env.Spec.Schedule
+	if schedule == nil {
+		return time.Time{}, nil
+	}
+
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	last := time.Time{}
+	now := time.Now().In(location)
+	for _, window := range schedule.Windows {
+		wake, err := cron.ParseStandard(window.Wake)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if wakeAt := lastOccurrence(wake, now); wakeAt.After(last) {
+			last = wakeAt
+		}
+	}
+
+	return last, nil
+}
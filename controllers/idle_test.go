@@ -0,0 +1,196 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// fakeActivity is an activity signal reporting a fixed activity or error
+type fakeActivity struct {
+	last time.Time
+	err  error
+}
+
+func (s fakeActivity) LastActivity(env *devv1alpha1.Environment) (time.Time, error) {
+	return s.last, s.err
+}
+
+func TestIsIdle(t *testing.T) {
+	now := time.Now()
+	signalErr := errors.New("prometheus is down")
+
+	tests := []struct {
+		name     string
+		signals  []ActivitySignal
+		wantIdle bool
+		wantErr  bool
+	}{
+		{
+			name:     "environment without activity is idle",
+			signals:  []ActivitySignal{fakeActivity{}, fakeActivity{}},
+			wantIdle: true,
+		},
+		{
+			name:    "recent activity of one signal keeps the environment active",
+			signals: []ActivitySignal{fakeActivity{}, fakeActivity{last: now.Add(-time.Minute)}},
+		},
+		{
+			name:    "failing signal is skipped",
+			signals: []ActivitySignal{fakeActivity{err: signalErr}, fakeActivity{last: now.Add(-time.Minute)}},
+		},
+		{
+			name:     "failing signal doesn't keep an unused environment active",
+			signals:  []ActivitySignal{fakeActivity{err: signalErr}, fakeActivity{last: now.Add(-2 * time.Hour)}},
+			wantIdle: true,
+		},
+		{
+			name:    "environment isn't idle when every signal fails",
+			signals: []ActivitySignal{fakeActivity{err: signalErr}, fakeActivity{err: signalErr}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newIdleEnvironment(devv1alpha1.IdleActionHibernate)
+			r := newTestReconciler(t, env)
+			r.ActivitySignals = tt.signals
+
+			idle, err := r.isIdle(env, now)
+			if idle != tt.wantIdle {
+				t.Errorf("got idle %t, want %t", idle, tt.wantIdle)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && env.Status.LastActivity == nil {
+				t.Errorf("last activity wasn't recorded")
+			}
+		})
+	}
+}
+
+func TestIdleActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      devv1alpha1.IdleAction
+		signals     []ActivitySignal
+		wantPhase   devv1alpha1.EnvironmentPhase
+		wantDeleted bool
+	}{
+		{
+			name:      "idle environment hibernates",
+			action:    devv1alpha1.IdleActionHibernate,
+			signals:   []ActivitySignal{fakeActivity{}},
+			wantPhase: devv1alpha1.PhaseSleeping,
+		},
+		{
+			name:        "idle environment is deleted",
+			action:      devv1alpha1.IdleActionDelete,
+			signals:     []ActivitySignal{fakeActivity{}},
+			wantPhase:   devv1alpha1.PhaseDeleting,
+			wantDeleted: true,
+		},
+		{
+			name:      "environment whose signals all fail is left running",
+			action:    devv1alpha1.IdleActionDelete,
+			signals:   []ActivitySignal{fakeActivity{err: errors.New("prometheus is down")}},
+			wantPhase: devv1alpha1.PhaseProvisioningCluster,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newIdleEnvironment(tt.action)
+			r := newTestReconciler(t, env)
+			r.ActivitySignals = tt.signals
+			pc := &phaseContext{env: env, provider: &stubProvider{class: env}}
+
+			phase, err := r.handlePending(pc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if phase != tt.wantPhase {
+				t.Errorf("got phase %s, want %s", phase, tt.wantPhase)
+			}
+
+			getErr := r.Client.Get(context.Background(), types.NamespacedName{Name: env.GetName()}, &devv1alpha1.Environment{})
+			if deleted := kerrors.IsNotFound(getErr); deleted != tt.wantDeleted {
+				t.Errorf("got environment deleted %t, want %t", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestPrometheusActivityIsCached(t *testing.T) {
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries++
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1580000000,"2"]}]}}`)
+	}))
+	defer server.Close()
+
+	env := newIdleEnvironment(devv1alpha1.IdleActionHibernate)
+	env.Spec.Idle.Prometheus = &devv1alpha1.PrometheusSignal{URL: server.URL, Query: "sum(rate(requests_total[5m]))"}
+	signal := newPrometheusActivity(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		activity, err := signal.LastActivity(env)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if time.Since(activity) > time.Minute {
+			t.Errorf("got activity %s, want now", activity)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("got %d queries, want 1 while the result is cached", queries)
+	}
+
+	env.Spec.Idle.Prometheus.Query = "sum(rate(requests_total[1m]))"
+	if _, err := signal.LastActivity(env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if queries != 2 {
+		t.Errorf("got %d queries, want a new query once the query changed", queries)
+	}
+}
+
+// newIdleEnvironment returns an environment created long ago with an idle policy of an hour
+func newIdleEnvironment(action devv1alpha1.IdleAction) *devv1alpha1.Environment {
+	env := newTestEnvironment()
+	env.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+	env.Spec.Idle = &devv1alpha1.IdlePolicy{
+		After:  metav1.Duration{Duration: time.Hour},
+		Action: action,
+	}
+
+	return env
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// kustomizeSource maps the kustomize spec of an application onto the argocd application source
+func kustomizeSource(kustomize *devv1alpha1.KustomizeSpec) *argocdapplicationv1alpha1.ApplicationSourceKustomize {
+	if kustomize == nil {
+		return nil
+	}
+
+	kustomizeSource := &argocdapplicationv1alpha1.ApplicationSourceKustomize{
+		NamePrefix:   kustomize.NamePrefix,
+		NameSuffix:   kustomize.NameSuffix,
+		CommonLabels: kustomize.CommonLabels,
+	}
+	for _, image := range kustomize.Images {
+		kustomizeSource.Images = append(kustomizeSource.Images, argocdapplicationv1alpha1.KustomizeImage(image))
+	}
+
+	return kustomizeSource
+}
+
+// directorySource maps the directory spec of an application onto the argocd application source
+func directorySource(directory *devv1alpha1.DirectorySpec) *argocdapplicationv1alpha1.ApplicationSourceDirectory {
+	if directory == nil {
+		return nil
+	}
+
+	directorySource := &argocdapplicationv1alpha1.ApplicationSourceDirectory{
+		Recurse: directory.Recurse,
+	}
+	if directory.Jsonnet != nil {
+		directorySource.Jsonnet.ExtVars = jsonnetVars(directory.Jsonnet.ExtVars)
+		directorySource.Jsonnet.TLAs = jsonnetVars(directory.Jsonnet.TLAs)
+	}
+
+	return directorySource
+}
+
+func jsonnetVars(vars []devv1alpha1.JsonnetVar) []argocdapplicationv1alpha1.JsonnetVar {
+	jsonnetVars := []argocdapplicationv1alpha1.JsonnetVar{}
+	for _, v := range vars {
+		jsonnetVars = append(jsonnetVars, argocdapplicationv1alpha1.JsonnetVar{
+			Name:  v.Name,
+			Value: v.Value,
+			Code:  v.Code,
+		})
+	}
+
+	return jsonnetVars
+}
+
+const (
+	defaultImageRepositoryParameter = "image.repository"
+	defaultImageTagParameter        = "image.tag"
+)
+
+// applyImageOverrides writes the image overrides into the helm parameters of helm sources
+// and into the kustomize images of every other source
+func applyImageOverrides(source *argocdapplicationv1alpha1.ApplicationSource, overrides []devv1alpha1.ImageOverride) {
+	if len(overrides) == 0 {
+		return
+	}
+
+	if source.Helm != nil || source.Chart != "" {
+		if source.Helm == nil {
+			source.Helm = &argocdapplicationv1alpha1.ApplicationSourceHelm{}
+		}
+		for _, override := range overrides {
+			parameters := override.HelmParameters
+			if parameters == nil {
+				parameters = &devv1alpha1.ImageHelmParameters{}
+			}
+
+			repositoryParameter, tagParameter := parameters.Repository, parameters.Tag
+			if repositoryParameter == "" {
+				repositoryParameter = defaultImageRepositoryParameter
+			}
+			if tagParameter == "" {
+				tagParameter = defaultImageTagParameter
+			}
+
+			setHelmParameter(source.Helm, repositoryParameter, override.Name)
+			// the webhook requires the digest parameter for digests, they aren't valid tags
+			switch {
+			case override.Digest != "" && parameters.Digest != "":
+				setHelmParameter(source.Helm, parameters.Digest, override.Digest)
+			case override.Digest == "":
+				setHelmParameter(source.Helm, tagParameter, override.Tag)
+			}
+		}
+		return
+	}
+
+	if source.Kustomize == nil {
+		source.Kustomize = &argocdapplicationv1alpha1.ApplicationSourceKustomize{}
+	}
+	for _, override := range overrides {
+		image := argocdapplicationv1alpha1.KustomizeImage(override.Reference())
+		if i := source.Kustomize.Images.Find(image); i >= 0 {
+			source.Kustomize.Images[i] = image
+			continue
+		}
+		source.Kustomize.Images = append(source.Kustomize.Images, image)
+	}
+}
+
+// setHelmParameter adds or replaces the helm parameter of the same name
+func setHelmParameter(helm *argocdapplicationv1alpha1.ApplicationSourceHelm, name, value string) {
+	for i := range helm.Parameters {
+		if helm.Parameters[i].Name == name {
+			helm.Parameters[i].Value = value
+			return
+		}
+	}
+
+	helm.Parameters = append(helm.Parameters, argocdapplicationv1alpha1.HelmParameter{Name: name, Value: value})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// notificationTimeout bounds how long a webhook may take, notifications are sent from the reconcile loop
+var notificationTimeout = 10 * time.Second
+
+// Notification is sent to the owner of an environment, e.g., before its TTL expires
+type Notification struct {
+	Environment string     `json:"environment"`
+	Cluster     string     `json:"cluster"`
+	Reason      string     `json:"reason"`
+	Message     string     `json:"message"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Notifier delivers notifications to a channel configured in `spec.notify`
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// SMTPConfig is the SMTP server used for email notifications
+type SMTPConfig struct {
+	// Addr is the host:port of the server, email notifications are disabled when empty
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// webhookNotifier posts the notification as JSON
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) Notify(notification Notification) error {
+	return postJSON(n.url, notification)
+}
+
+// slackNotifier posts the message in the payload of slack incoming webhooks
+type slackNotifier struct {
+	url string
+}
+
+func (n slackNotifier) Notify(notification Notification) error {
+	return postJSON(n.url, map[string]string{
+		"text": fmt.Sprintf("*%s*: %s", notification.Environment, notification.Message),
+	})
+}
+
+// emailNotifier sends the notification as a plain text email
+type emailNotifier struct {
+	smtp SMTPConfig
+	to   []string
+}
+
+func (n emailNotifier) Notify(notification Notification) error {
+	var auth smtp.Auth
+	if n.smtp.Username != "" {
+		host, _, err := net.SplitHostPort(n.smtp.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.smtp.Username, n.smtp.Password, host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [%s] %s\r\n\r\n%s\r\n",
+		n.smtp.From, strings.Join(n.to, ", "), notification.Environment, notification.Reason, notification.Message)
+	return smtp.SendMail(n.smtp.Addr, auth, n.smtp.From, n.to, []byte(message))
+}
+
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: notificationTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// notifiers returns the notifiers configured for the environment
+func (r *EnvironmentReconciler) notifiers(env *devv1alpha1.Environment) ([]Notifier, error) {
+	notify := env.Spec.Notify
+	if notify == nil {
+		return nil, nil
+	}
+
+	notifiers := []Notifier{}
+	if notify.Webhook != nil {
+		url, err := r.notificationURL(notify.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhookNotifier{url})
+	}
+	if notify.Slack != nil {
+		url, err := r.notificationURL(notify.Slack)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, slackNotifier{url})
+	}
+	if notify.Email != nil {
+		to := notify.Email.To
+		if len(to) == 0 && env.Spec.Owner != "" {
+			to = []string{env.Spec.Owner}
+		}
+		if r.SMTP.Addr == "" || len(to) == 0 {
+			return nil, fmt.Errorf("email notifications need the controller's SMTP server and a recipient")
+		}
+		notifiers = append(notifiers, emailNotifier{r.SMTP, to})
+	}
+
+	return notifiers, nil
+}
+
+func (r *EnvironmentReconciler) notificationURL(webhook *devv1alpha1.WebhookNotification) (string, error) {
+	if webhook.URLFrom == nil {
+		return webhook.URL, nil
+	}
+
+	url, err := r.resolveValue(webhook.URLFrom)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve notification url: %v", err)
+	}
+
+	return strings.TrimSpace(url), nil
+}
+
+// notify records an event on the environment and sends the notification to its notifiers.
+// Notifications that can't be delivered are recorded as events, they never block the reconcile.
+func (r *EnvironmentReconciler) notify(env *devv1alpha1.Environment, reason, message string) {
+	r.Recorder.Event(env, corev1.EventTypeWarning, reason, message)
+
+	notification := Notification{
+		Environment: env.GetName(),
+		Cluster:     env.Spec.ClusterName,
+		Reason:      reason,
+		Message:     message,
+	}
+	if env.Status.ExpiresAt != nil {
+		expiresAt := env.Status.ExpiresAt.UTC()
+		notification.ExpiresAt = &expiresAt
+	}
+
+	notifiers, err := r.notifiers(env)
+	if err != nil {
+		r.Log.Error(err, "could not configure the notifications of the environment")
+		r.Recorder.Event(env, corev1.EventTypeWarning, "NotificationFailed", err.Error())
+		return
+	}
+
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(notification); err != nil {
+			r.Log.Error(err, "could not send notification", "reason", reason)
+			r.Recorder.Event(env, corev1.EventTypeWarning, "NotificationFailed", fmt.Sprintf("could not send %s notification: %v", reason, err))
+		}
+	}
+}
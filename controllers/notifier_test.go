@@ -0,0 +1,194 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// recordingServer returns a server responding with the status that records the bodies it receives
+func recordingServer(t *testing.T, status int, delay time.Duration) (*httptest.Server, <-chan []byte) {
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("got content type %s, want application/json", contentType)
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("could not read the request body: %v", err)
+		}
+		bodies <- body
+
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+
+	return server, bodies
+}
+
+func TestNotifiers(t *testing.T) {
+	expiresAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	notification := Notification{
+		Environment: "test-env",
+		Cluster:     "test-cluster",
+		Reason:      "TTLExpiring",
+		Message:     "environment 'test-env' expires in 1h0m0s",
+		ExpiresAt:   &expiresAt,
+	}
+
+	tests := []struct {
+		name        string
+		notifier    func(url string) Notifier
+		status      int
+		wantPayload map[string]string
+		wantErr     bool
+	}{
+		{
+			name:     "webhook receives the notification",
+			notifier: func(url string) Notifier { return webhookNotifier{url} },
+			status:   http.StatusOK,
+			wantPayload: map[string]string{
+				"environment": "test-env",
+				"cluster":     "test-cluster",
+				"reason":      "TTLExpiring",
+				"message":     "environment 'test-env' expires in 1h0m0s",
+				"expiresAt":   "2020-01-02T03:04:05Z",
+			},
+		},
+		{
+			name:     "slack receives the message as text",
+			notifier: func(url string) Notifier { return slackNotifier{url} },
+			status:   http.StatusOK,
+			wantPayload: map[string]string{
+				"text": "*test-env*: environment 'test-env' expires in 1h0m0s",
+			},
+		},
+		{
+			name:     "webhook responding with an error fails",
+			notifier: func(url string) Notifier { return webhookNotifier{url} },
+			status:   http.StatusInternalServerError,
+			wantErr:  true,
+		},
+		{
+			name:     "slack responding with an error fails",
+			notifier: func(url string) Notifier { return slackNotifier{url} },
+			status:   http.StatusNotFound,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, bodies := recordingServer(t, tt.status, 0)
+			defer server.Close()
+
+			err := tt.notifier(server.URL).Notify(notification)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantPayload == nil {
+				return
+			}
+
+			payload := map[string]string{}
+			if err := json.Unmarshal(<-bodies, &payload); err != nil {
+				t.Fatalf("could not decode the payload: %v", err)
+			}
+			if len(payload) != len(tt.wantPayload) {
+				t.Errorf("got payload %v, want %v", payload, tt.wantPayload)
+			}
+			for key, value := range tt.wantPayload {
+				if payload[key] != value {
+					t.Errorf("got %s '%s', want '%s'", key, payload[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookOmitsMissingExpiry(t *testing.T) {
+	server, bodies := recordingServer(t, http.StatusOK, 0)
+	defer server.Close()
+
+	if err := (webhookNotifier{server.URL}).Notify(Notification{Environment: "test-env", Reason: "BudgetWarning"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body := string(<-bodies); strings.Contains(body, "expiresAt") {
+		t.Errorf("got payload %s, want it without expiresAt", body)
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	defaultTimeout := notificationTimeout
+	notificationTimeout = 50 * time.Millisecond
+	defer func() { notificationTimeout = defaultTimeout }()
+
+	server, _ := recordingServer(t, http.StatusOK, time.Second)
+	defer server.Close()
+
+	start := time.Now()
+	if err := (webhookNotifier{server.URL}).Notify(Notification{Environment: "test-env"}); err == nil {
+		t.Errorf("got no error from a webhook slower than the timeout")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("notification took %s, want it cut off after %s", elapsed, notificationTimeout)
+	}
+}
+
+func TestNotifyRecordsUndeliveredNotifications(t *testing.T) {
+	server, bodies := recordingServer(t, http.StatusBadGateway, 0)
+	defer server.Close()
+
+	env := newTestEnvironment()
+	env.Spec.Notify = &devv1alpha1.NotifySpec{Webhook: &devv1alpha1.WebhookNotification{URL: server.URL}}
+	expiresAt := metav1.NewTime(time.Now().Add(time.Hour))
+	env.Status.ExpiresAt = &expiresAt
+	r := newTestReconciler(t, env)
+
+	r.notify(env, "TTLExpiring", "environment 'test-env' expires in 1h0m0s")
+
+	notification := Notification{}
+	if err := json.Unmarshal(<-bodies, &notification); err != nil {
+		t.Fatalf("could not decode the payload: %v", err)
+	}
+	if notification.ExpiresAt == nil || notification.ExpiresAt.Unix() != expiresAt.Unix() {
+		t.Errorf("got expiry %v, want %v", notification.ExpiresAt, expiresAt)
+	}
+
+	events := r.Recorder.(*record.FakeRecorder).Events
+	for _, wantReason := range []string{"TTLExpiring", "NotificationFailed"} {
+		select {
+		case event := <-events:
+			if !strings.Contains(event, wantReason) {
+				t.Errorf("got event '%s', want a %s event", event, wantReason)
+			}
+		default:
+			t.Errorf("got no %s event", wantReason)
+		}
+	}
+}
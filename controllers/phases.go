@@ -0,0 +1,459 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// phaseContext is the state the phase handlers of a reconcile share
+type phaseContext struct {
+	env      *devv1alpha1.Environment
+	provider ClusterProvider
+
+	// clusterClass is resolved in the Pending phase
+	clusterClass runtime.Object
+	// managedResourceName is the name of the managed cluster, set once the cluster claim is bound
+	managedResourceName string
+	// dependencyGraph maps each application to the dependencies it depends on, set in the DeployingDependencies phase
+	dependencyGraph map[string][]string
+	// readyDependencies are the dependencies found ready in the DeployingDependencies phase
+	readyDependencies map[string]bool
+	// failure is why the environment is in the Failed phase
+	failure error
+}
+
+// phaseHandler does the work of a phase and returns the phase the environment transitions to.
+// Returning the same phase means the environment waits in it until the next reconcile.
+type phaseHandler func(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error)
+
+// phaseHandlers returns the handler of each phase. The transitions are:
+//
+//	Pending -> ProvisioningCluster -> ProvisioningNodePool -> DeployingDependencies -> DeployingSource -> Ready
+//	Ready <-> Expiring -> Deleting
+//	Pending <-> Sleeping -> Deleting
+//	Pending, DeployingDependencies -> Failed
+//
+// Every phase can also be left for Deleting when the environment is deleted.
+func (r *EnvironmentReconciler) phaseHandlers() map[devv1alpha1.EnvironmentPhase]phaseHandler {
+	return map[devv1alpha1.EnvironmentPhase]phaseHandler{
+		devv1alpha1.PhasePending:               r.handlePending,
+		devv1alpha1.PhaseProvisioningCluster:   r.handleProvisioningCluster,
+		devv1alpha1.PhaseProvisioningNodePool:  r.handleProvisioningNodePool,
+		devv1alpha1.PhaseDeployingDependencies: r.handleDeployingDependencies,
+		devv1alpha1.PhaseDeployingSource:       r.handleDeployingSource,
+		devv1alpha1.PhaseReady:                 r.handleReady,
+		devv1alpha1.PhaseExpiring:              r.handleReady,
+		devv1alpha1.PhaseSleeping:              r.handleSleeping,
+		devv1alpha1.PhaseDeleting:              r.handleDeleting,
+	}
+}
+
+// runPhases runs the phase handlers starting from the given phase until one of them waits.
+// Every reconcile starts from the first phase so that drift (e.g., a deleted application) is repaired.
+func (r *EnvironmentReconciler) runPhases(pc *phaseContext, phase devv1alpha1.EnvironmentPhase) (devv1alpha1.EnvironmentPhase, error) {
+	handlers := r.phaseHandlers()
+	for {
+		handler, ok := handlers[phase]
+		if !ok {
+			return phase, nil
+		}
+
+		next, err := handler(pc)
+		if next == devv1alpha1.PhaseFailed {
+			pc.failure = err
+			return next, nil
+		}
+		if err != nil {
+			return phase, err
+		}
+
+		if next == phase {
+			return phase, nil
+		}
+		r.Log.Info("environment changed phase", "environment", pc.env.GetName(), "from", phase, "to", next)
+		phase = next
+	}
+}
+
+// handlePending checks the environment has a source, is within its budget and awake and resolves its cluster class
+func (r *EnvironmentReconciler) handlePending(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	if len(pc.env.Spec.SourceApplications()) == 0 {
+		return devv1alpha1.PhaseFailed, fmt.Errorf("environment has no source, set source or sources")
+	}
+
+	if err := r.updateCost(pc, time.Now()); err != nil {
+		// the budget is enforced with the last estimate until the cost can be estimated again
+		r.Log.Error(err, "could not estimate the cost of the environment")
+	}
+	if isOverBudget(pc.env) {
+		return r.exhaustBudget(pc.env)
+	}
+	r.warnBeforeBudget(pc.env)
+
+	awake, scheduleErr := isAwake(pc.env.Spec.Schedule, time.Now())
+	if scheduleErr != nil {
+		return devv1alpha1.PhaseFailed, scheduleErr
+	}
+	if !awake {
+		return devv1alpha1.PhaseSleeping, nil
+	}
+
+	idle, idleErr := r.isIdle(pc.env, time.Now())
+	if idleErr != nil {
+		// a signal that can't be read doesn't make the environment idle
+		r.Log.Error(idleErr, "could not evaluate the idle policy of the environment")
+	}
+	if idle {
+		if pc.env.Spec.Idle.Action == devv1alpha1.IdleActionDelete {
+			return r.deleteIdle(pc.env)
+		}
+		return devv1alpha1.PhaseSleeping, nil
+	}
+	if pc.env.Status.Phase == devv1alpha1.PhaseSleeping {
+		if err := r.wake(pc); err != nil {
+			r.Log.Error(err, "could not wake the environment up")
+			return devv1alpha1.PhasePending, err
+		}
+	}
+
+	k8class, fetchClassErr := pc.provider.FetchClusterClass(pc.env)
+	if fetchClassErr != nil {
+		r.Log.Error(fetchClassErr, "could not get cluster class referenced in the environment", "cluster-class",
+			pc.env.Spec.ClusterClassLabel,
+			"namespace", r.CrossplaneNamespace)
+		if kerrors.IsNotFound(fetchClassErr) {
+			return devv1alpha1.PhaseFailed, fmt.Errorf("cluster class '%s' doesn't exist", pc.env.Spec.ClusterClassLabel)
+		}
+		return devv1alpha1.PhasePending, fetchClassErr
+	}
+
+	pc.clusterClass = k8class
+	return devv1alpha1.PhaseProvisioningCluster, nil
+}
+
+// handleProvisioningCluster claims the cluster and waits for it to be ready
+func (r *EnvironmentReconciler) handleProvisioningCluster(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	managedResourceName, createClusterErr := pc.provider.CreateClusterClaim(pc.env, pc.clusterClass)
+	if createClusterErr != nil {
+		r.Log.Error(createClusterErr, "could not get created kubernetes clusterclaim", "cluster-class", pc.env.Spec.ClusterClassLabel)
+		return devv1alpha1.PhaseProvisioningCluster, createClusterErr
+	}
+
+	// the claim isn't bound yet
+	if managedResourceName == "" || !pc.provider.IsClusterReady(pc.env) {
+		return devv1alpha1.PhaseProvisioningCluster, nil
+	}
+
+	pc.managedResourceName = managedResourceName
+	return devv1alpha1.PhaseProvisioningNodePool, nil
+}
+
+// handleProvisioningNodePool creates the node pools of the cluster and waits for them to be running
+func (r *EnvironmentReconciler) handleProvisioningNodePool(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	createNodepoolErr := pc.provider.CreateNodePools(pc.env, pc.clusterClass, pc.managedResourceName)
+	if createNodepoolErr != nil {
+		r.Log.Error(createNodepoolErr, "could not create nodepool for the cluster", "nodepool name", pc.env.Spec.ClusterName, "cluster name", pc.env.Spec.ClusterName)
+		return devv1alpha1.PhaseProvisioningNodePool, createNodepoolErr
+	}
+
+	if !pc.provider.AreNodePoolsReady(pc.env) {
+		return devv1alpha1.PhaseProvisioningNodePool, nil
+	}
+
+	return devv1alpha1.PhaseDeployingDependencies, nil
+}
+
+// handleDeployingDependencies creates the argocd applications of the dependencies in the order of
+// their dependsOn, each once its predecessors are ready, after pruning applications removed from
+// the spec. It moves on to the sources once the dependencies one of the sources depends on are ready.
+func (r *EnvironmentReconciler) handleDeployingDependencies(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	if err := r.pruneApps(pc.env); err != nil {
+		return devv1alpha1.PhaseDeployingDependencies, err
+	}
+
+	graph, graphErr := pc.env.DependencyGraph()
+	if graphErr != nil {
+		return devv1alpha1.PhaseFailed, graphErr
+	}
+	order, orderErr := pc.env.DependencyOrder()
+	if orderErr != nil {
+		return devv1alpha1.PhaseFailed, orderErr
+	}
+
+	ready := map[string]bool{}
+	for _, name := range order {
+		if waitingFor := notReady(graph[name], ready); len(waitingFor) > 0 {
+			r.Log.Info("dependency is waiting for its predecessors", "dependency", name, "waiting-for", waitingFor)
+			continue
+		}
+
+		dependency := dependencyByName(pc.env, name)
+		if _, err := r.ensureApp(pc.env, name, func() (*argocdapplicationv1alpha1.Application, error) {
+			return r.getDependencyApp(dependency, pc.env)
+		}); err != nil {
+			return devv1alpha1.PhaseDeployingDependencies, err
+		}
+		ready[name] = r.isArgoCDAppReady(pc.env, name)
+	}
+
+	pc.dependencyGraph = graph
+	pc.readyDependencies = ready
+	for _, source := range pc.env.Spec.SourceApplications() {
+		if len(notReady(graph[source.Name], ready)) == 0 {
+			return devv1alpha1.PhaseDeployingSource, nil
+		}
+	}
+
+	return devv1alpha1.PhaseDeployingDependencies, nil
+}
+
+// handleDeployingSource creates the argocd applications of the sources whose dependencies are ready
+// and waits for all sources and dependencies to be ready
+func (r *EnvironmentReconciler) handleDeployingSource(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	images := []string{}
+	for _, source := range pc.env.Spec.SourceApplications() {
+		if waitingFor := notReady(pc.dependencyGraph[source.Name], pc.readyDependencies); len(waitingFor) > 0 {
+			r.Log.Info("source is waiting for its dependencies", "source", source.Name, "waiting-for", waitingFor)
+			continue
+		}
+
+		sourceApp, err := r.ensureApp(pc.env, source.Name, func() (*argocdapplicationv1alpha1.Application, error) {
+			return r.getSourceApp(source, pc.env)
+		})
+		if err != nil {
+			return devv1alpha1.PhaseDeployingSource, err
+		}
+		images = appendMissing(images, sourceApp.Status.Summary.Images...)
+	}
+	pc.env.Status.Images = images
+
+	// dependencies the sources don't depend on might still be deploying
+	if !r.areArgoCDAppSourcesReady(pc.env) || !r.areArgoCDAppDependenciesReady(pc.env) {
+		return devv1alpha1.PhaseDeployingSource, nil
+	}
+
+	return devv1alpha1.PhaseReady, nil
+}
+
+// handleReady starts the TTL of a ready environment and deletes the environment once the TTL is exceeded.
+// The expiry is computed on every reconcile, so changes of `spec.ttl` and extensions apply right away.
+func (r *EnvironmentReconciler) handleReady(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	env := pc.env
+	if err := r.extendTTL(env); err != nil {
+		return devv1alpha1.PhaseReady, err
+	}
+
+	if env.Spec.TTL == "" {
+		env.Status.ExpiresAt = nil
+		return devv1alpha1.PhaseReady, nil
+	}
+
+	now := metav1.Now()
+	if env.Status.TTLStartTimestamp.IsZero() {
+		env.Status.TTLStartTimestamp = &now
+	}
+
+	if suspendTTL(env, now) {
+		return devv1alpha1.PhaseReady, nil
+	}
+
+	expiresAt := metav1.NewTime(ttlExpiresAt(env))
+	env.Status.ExpiresAt = &expiresAt
+	if time.Now().UTC().After(expiresAt.Time) {
+		return r.deleteExpired(env)
+	}
+
+	r.warnBeforeExpiry(env, expiresAt.Time)
+	if time.Until(expiresAt.Time) < ttlExpiringThreshold {
+		return devv1alpha1.PhaseExpiring, nil
+	}
+
+	return devv1alpha1.PhaseReady, nil
+}
+
+// suspendTTL records when the TTL of the environment was suspended and returns true while it is.
+// The TTL doesn't run while it's suspended, so its start is moved by the suspension once it's resumed.
+func suspendTTL(env *devv1alpha1.Environment, now metav1.Time) bool {
+	if env.Spec.SuspendTTL {
+		if env.Status.TTLSuspendedTimestamp.IsZero() {
+			env.Status.TTLSuspendedTimestamp = &now
+		}
+		env.Status.ExpiresAt = nil
+		return true
+	}
+
+	if !env.Status.TTLSuspendedTimestamp.IsZero() {
+		resumedStart := metav1.NewTime(env.Status.TTLStartTimestamp.Add(now.Sub(env.Status.TTLSuspendedTimestamp.Time)))
+		env.Status.TTLStartTimestamp = &resumedStart
+		env.Status.TTLSuspendedTimestamp = nil
+	}
+
+	return false
+}
+
+// deleteExpired deletes an environment that exceeded its TTL
+func (r *EnvironmentReconciler) deleteExpired(env *devv1alpha1.Environment) (devv1alpha1.EnvironmentPhase, error) {
+	r.Log.Info(fmt.Sprintf("cluster '%s' exceeded TTL of %s (expired at %s)", env.Spec.ClusterName, env.Spec.TTL, ttlExpiresAt(env)))
+	r.notify(env, "TTLExceeded", fmt.Sprintf("environment '%s' exceeded its TTL of %s and is being deleted", env.GetName(), env.Spec.TTL))
+	r.Log.Info("deleting the cluster")
+	deleteErr := r.Delete(context.Background(), env)
+	if deleteErr != nil && !kerrors.IsNotFound(deleteErr) {
+		r.Log.Error(deleteErr, "could not delete the environment even after exceeding TTL")
+		return devv1alpha1.PhaseExpiring, deleteErr
+	}
+
+	return devv1alpha1.PhaseDeleting, nil
+}
+
+// deleteIdle deletes an environment that wasn't used within its idle policy
+func (r *EnvironmentReconciler) deleteIdle(env *devv1alpha1.Environment) (devv1alpha1.EnvironmentPhase, error) {
+	r.notify(env, "Idle", fmt.Sprintf("environment '%s' wasn't used since %s and is being deleted",
+		env.GetName(), env.Status.LastActivity.UTC().Format(time.RFC3339)))
+	deleteErr := r.Delete(context.Background(), env)
+	if deleteErr != nil && !kerrors.IsNotFound(deleteErr) {
+		r.Log.Error(deleteErr, "could not delete the idle environment")
+		return devv1alpha1.PhasePending, deleteErr
+	}
+
+	return devv1alpha1.PhaseDeleting, nil
+}
+
+// handleDeleting tears a deleted environment down in order: the argocd applications, the node pools
+// and then the cluster. Every step waits for its objects to be gone before its finalizer is removed.
+func (r *EnvironmentReconciler) handleDeleting(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	env := pc.env
+	if env.ObjectMeta.DeletionTimestamp.IsZero() {
+		return devv1alpha1.PhaseDeleting, nil
+	}
+
+	for _, step := range r.teardownSteps(pc.provider) {
+		if !containsString(env.ObjectMeta.Finalizers, step.finalizer) {
+			continue
+		}
+
+		remaining, err := step.delete(env)
+		if err != nil {
+			r.Log.Error(err, "could not tear the environment down", "step", step.step)
+			return devv1alpha1.PhaseDeleting, err
+		}
+		if len(remaining) > 0 {
+			r.Log.Info("waiting for the teardown step to finish", "step", step.step, "waiting for", remaining)
+			env.Status.Teardown = &devv1alpha1.TeardownStatus{Step: step.step, Waiting: remaining}
+			return devv1alpha1.PhaseDeleting, nil
+		}
+
+		if err := r.removeFinalizer(env, step.finalizer); err != nil {
+			return devv1alpha1.PhaseDeleting, err
+		}
+	}
+
+	env.Status.Teardown = nil
+	return devv1alpha1.PhaseDeleting, nil
+}
+
+// notReady returns the names that aren't ready
+func notReady(names []string, ready map[string]bool) []string {
+	waitingFor := []string{}
+	for _, name := range names {
+		if !ready[name] {
+			waitingFor = append(waitingFor, name)
+		}
+	}
+
+	return waitingFor
+}
+
+// appendMissing appends the names that aren't in the slice yet
+func appendMissing(names []string, newNames ...string) []string {
+	for _, name := range newNames {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func dependencyByName(env *devv1alpha1.Environment, name string) *devv1alpha1.DependencySrc {
+	for i := range env.Spec.Dependencies {
+		if env.Spec.Dependencies[i].Name == name {
+			return &env.Spec.Dependencies[i]
+		}
+	}
+
+	return nil
+}
+
+// ensureApp creates the argocd application of an application of the spec when it doesn't exist
+// and patches it when it differs from the spec
+func (r *EnvironmentReconciler) ensureApp(env *devv1alpha1.Environment, name string, newApp func() (*argocdapplicationv1alpha1.Application, error)) (*argocdapplicationv1alpha1.Application, error) {
+	argocdApp, fetchErr := r.fetchApp(env, name)
+	if fetchErr != nil && !kerrors.IsNotFound(fetchErr) {
+		r.Log.Error(fetchErr, "could not get argocd application", "name", name)
+		return nil, fetchErr
+	}
+
+	desiredApp, newAppErr := newApp()
+	if newAppErr != nil {
+		return nil, newAppErr
+	}
+
+	ref := applicationRef(env, name)
+	if fetchErr != nil {
+		r.Log.Info("creating argocd application", "name", name)
+		var createAppErr error
+		argocdApp, createAppErr = r.createArgoCDApp(env, desiredApp)
+		if createAppErr != nil {
+			return nil, createAppErr
+		}
+		r.Log.Info("created argocd application", "name", name, "application", argocdApp)
+	} else {
+		patched, patchErr := r.patchArgoCDApp(argocdApp, desiredApp)
+		if patchErr != nil {
+			return nil, patchErr
+		}
+		if patched {
+			now := metav1.Now()
+			ref.LastPatchTime = &now
+		}
+	}
+
+	ref.ApplicationName = argocdApp.GetName()
+	return argocdApp, nil
+}
+
+// applicationRef returns the reference to the argocd application of an application of the spec, adding it when it doesn't exist
+func applicationRef(env *devv1alpha1.Environment, name string) *devv1alpha1.ApplicationRef {
+	for i := range env.Status.Applications {
+		if env.Status.Applications[i].Name == name {
+			return &env.Status.Applications[i]
+		}
+	}
+
+	env.Status.Applications = append(env.Status.Applications, devv1alpha1.ApplicationRef{Name: name})
+	return &env.Status.Applications[len(env.Status.Applications)-1]
+}
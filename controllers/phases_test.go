@@ -0,0 +1,604 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+const testArgoCDNamespace = "argocd"
+
+// stubProvider is a ClusterProvider whose answers are set by the test
+type stubProvider struct {
+	class          runtime.Object
+	classErr       error
+	managedName    string
+	clusterReady   bool
+	nodePoolsReady bool
+}
+
+func (p *stubProvider) FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error) {
+	return p.class, p.classErr
+}
+
+func (p *stubProvider) CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error) {
+	return p.managedName, nil
+}
+
+func (p *stubProvider) CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error {
+	return nil
+}
+
+func (p *stubProvider) IsClusterReady(env *devv1alpha1.Environment) bool {
+	return p.clusterReady
+}
+
+func (p *stubProvider) AreNodePoolsReady(env *devv1alpha1.Environment) bool {
+	return p.nodePoolsReady
+}
+
+// stubFinalizerProvider is a stubProvider whose cluster has to be deleted explicitly
+type stubFinalizerProvider struct {
+	stubProvider
+	deleted bool
+}
+
+func (p *stubFinalizerProvider) Finalizer() string {
+	return LocalClusterFinalizer
+}
+
+func (p *stubFinalizerProvider) DeleteCluster(env *devv1alpha1.Environment) error {
+	p.deleted = true
+	return nil
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		devv1alpha1.AddToScheme,
+		argocdapplicationv1alpha1.AddToScheme,
+	} {
+		if err := addToScheme(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return scheme
+}
+
+// newTestReconciler returns a reconciler backed by a fake client holding the objects
+func newTestReconciler(t *testing.T, objs ...runtime.Object) *EnvironmentReconciler {
+	scheme := newTestScheme(t)
+	return &EnvironmentReconciler{
+		Client:              fake.NewFakeClientWithScheme(scheme, objs...),
+		Log:                 ctrl.Log.WithName("test"),
+		Scheme:              scheme,
+		CrossplaneNamespace: "crossplane-system",
+		ArgoCDNamespace:     testArgoCDNamespace,
+		Recorder:            record.NewFakeRecorder(100),
+	}
+}
+
+// newTestEnvironment returns an environment with a source and the dependencies
+func newTestEnvironment(dependencies ...string) *devv1alpha1.Environment {
+	env := &devv1alpha1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-env",
+			UID:               types.UID("test-env-uid"),
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute)),
+		},
+		Spec: devv1alpha1.EnvironmentSpec{
+			ClusterName: "test-env",
+			Source: &devv1alpha1.AppSrc{
+				Name:      "app",
+				RepoURL:   "https://github.com/example/app",
+				Path:      "deploy",
+				Namespace: "default",
+			},
+		},
+	}
+	for _, name := range dependencies {
+		env.Spec.Dependencies = append(env.Spec.Dependencies, devv1alpha1.DependencySrc{
+			Name:      name,
+			RepoURL:   "https://charts.example.com",
+			ChartName: name,
+			Namespace: "default",
+		})
+	}
+
+	return env
+}
+
+// newTestApp returns the argocd application the reconciler would create for an application of the environment
+func newTestApp(t *testing.T, r *EnvironmentReconciler, env *devv1alpha1.Environment, name string, ready bool) *argocdapplicationv1alpha1.Application {
+	app := &argocdapplicationv1alpha1.Application{
+		ObjectMeta: r.applicationObjectMeta(env, name),
+	}
+	if err := ctrl.SetControllerReference(env, app, r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	if ready {
+		app.Status.Health.Status = argocdapplicationv1alpha1.HealthStatusHealthy
+		app.Status.Sync.Status = argocdapplicationv1alpha1.SyncStatusCodeSynced
+		app.Status.Summary.Images = []string{"example/" + name + ":1.0"}
+	}
+
+	return app
+}
+
+func TestHandlePending(t *testing.T) {
+	noSource := newTestEnvironment()
+	noSource.Spec.Source = nil
+
+	asleep := newTestEnvironment()
+	asleep.Spec.Schedule = &devv1alpha1.ScheduleSpec{
+		Timezone: "UTC",
+		// never awake
+		Windows: []devv1alpha1.ScheduleWindow{{Wake: "0 0 30 2 *", Sleep: "* * * * *"}},
+	}
+
+	tests := []struct {
+		name      string
+		env       *devv1alpha1.Environment
+		provider  *stubProvider
+		wantPhase devv1alpha1.EnvironmentPhase
+		wantErr   bool
+		wantClass bool
+	}{
+		{
+			name:      "environment without a source fails",
+			env:       noSource,
+			provider:  &stubProvider{},
+			wantPhase: devv1alpha1.PhaseFailed,
+			wantErr:   true,
+		},
+		{
+			name:      "missing cluster class fails",
+			env:       newTestEnvironment(),
+			provider:  &stubProvider{classErr: kerrors.NewNotFound(corev1.Resource("clusterclass"), "missing")},
+			wantPhase: devv1alpha1.PhaseFailed,
+			wantErr:   true,
+		},
+		{
+			name:      "cluster class that can't be fetched is retried",
+			env:       newTestEnvironment(),
+			provider:  &stubProvider{classErr: errors.New("connection refused")},
+			wantPhase: devv1alpha1.PhasePending,
+			wantErr:   true,
+		},
+		{
+			name:      "environment outside its schedule sleeps",
+			env:       asleep,
+			provider:  &stubProvider{},
+			wantPhase: devv1alpha1.PhaseSleeping,
+		},
+		{
+			name:      "cluster class is resolved",
+			env:       newTestEnvironment(),
+			provider:  &stubProvider{class: &corev1.ConfigMap{}},
+			wantPhase: devv1alpha1.PhaseProvisioningCluster,
+			wantClass: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(t, tt.env)
+			pc := &phaseContext{env: tt.env, provider: tt.provider}
+
+			phase, err := r.handlePending(pc)
+			if phase != tt.wantPhase {
+				t.Errorf("got phase %s, want %s", phase, tt.wantPhase)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if (pc.clusterClass != nil) != tt.wantClass {
+				t.Errorf("got cluster class %v, want cluster class %t", pc.clusterClass, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestHandleDeployingDependencies(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies []string
+		readyApps    []string
+		wantPhase    devv1alpha1.EnvironmentPhase
+		wantApps     []string
+	}{
+		{
+			name:      "environment without dependencies deploys its source",
+			wantPhase: devv1alpha1.PhaseDeployingSource,
+		},
+		{
+			name:         "dependencies are created and waited for",
+			dependencies: []string{"postgres"},
+			wantPhase:    devv1alpha1.PhaseDeployingDependencies,
+			wantApps:     []string{"postgres"},
+		},
+		{
+			name:         "ready dependencies move on to the source",
+			dependencies: []string{"postgres"},
+			readyApps:    []string{"postgres"},
+			wantPhase:    devv1alpha1.PhaseDeployingSource,
+			wantApps:     []string{"postgres"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment(tt.dependencies...)
+			r := newTestReconciler(t, env)
+			for _, name := range tt.readyApps {
+				if err := r.Client.Create(context.Background(), newTestApp(t, r, env, name, true)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pc := &phaseContext{env: env, provider: &stubProvider{}}
+
+			phase, err := r.handleDeployingDependencies(pc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if phase != tt.wantPhase {
+				t.Errorf("got phase %s, want %s", phase, tt.wantPhase)
+			}
+			assertApplications(t, r, env, tt.wantApps)
+		})
+	}
+}
+
+func TestHandleDeployingSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		readyApps  []string
+		wantPhase  devv1alpha1.EnvironmentPhase
+		wantImages []string
+	}{
+		{
+			name:       "source is created and waited for",
+			wantPhase:  devv1alpha1.PhaseDeployingSource,
+			wantImages: []string{},
+		},
+		{
+			name:       "ready source makes the environment ready",
+			readyApps:  []string{"app"},
+			wantPhase:  devv1alpha1.PhaseReady,
+			wantImages: []string{"example/app:1.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment()
+			r := newTestReconciler(t, env)
+			for _, name := range tt.readyApps {
+				if err := r.Client.Create(context.Background(), newTestApp(t, r, env, name, true)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pc := &phaseContext{env: env, provider: &stubProvider{}}
+
+			phase, err := r.handleDeployingSource(pc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if phase != tt.wantPhase {
+				t.Errorf("got phase %s, want %s", phase, tt.wantPhase)
+			}
+			assertApplications(t, r, env, []string{"app"})
+			if len(env.Status.Images) != len(tt.wantImages) || (len(tt.wantImages) > 0 && env.Status.Images[0] != tt.wantImages[0]) {
+				t.Errorf("got images %v, want %v", env.Status.Images, tt.wantImages)
+			}
+		})
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         string
+		ttlStart    time.Duration
+		wantPhase   devv1alpha1.EnvironmentPhase
+		wantExpires bool
+		wantDeleted bool
+	}{
+		{
+			name:      "environment without a TTL stays ready",
+			wantPhase: devv1alpha1.PhaseReady,
+		},
+		{
+			name:        "TTL starts when the environment is ready",
+			ttl:         "2d",
+			wantPhase:   devv1alpha1.PhaseReady,
+			wantExpires: true,
+		},
+		{
+			name:        "environment close to its expiry is expiring",
+			ttl:         "2h",
+			ttlStart:    90 * time.Minute,
+			wantPhase:   devv1alpha1.PhaseExpiring,
+			wantExpires: true,
+		},
+		{
+			name:        "expired environment is deleted",
+			ttl:         "1h",
+			ttlStart:    2 * time.Hour,
+			wantPhase:   devv1alpha1.PhaseDeleting,
+			wantExpires: true,
+			wantDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment()
+			env.Spec.TTL = tt.ttl
+			if tt.ttlStart > 0 {
+				start := metav1.NewTime(time.Now().Add(-tt.ttlStart))
+				env.Status.TTLStartTimestamp = &start
+			}
+			r := newTestReconciler(t, env)
+			pc := &phaseContext{env: env, provider: &stubProvider{}}
+
+			phase, err := r.handleReady(pc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if phase != tt.wantPhase {
+				t.Errorf("got phase %s, want %s", phase, tt.wantPhase)
+			}
+			if (env.Status.ExpiresAt != nil) != tt.wantExpires {
+				t.Errorf("got expiry %v, want expiry %t", env.Status.ExpiresAt, tt.wantExpires)
+			}
+			if tt.ttl != "" && env.Status.TTLStartTimestamp.IsZero() {
+				t.Errorf("TTL didn't start")
+			}
+
+			getErr := r.Client.Get(context.Background(), types.NamespacedName{Name: env.GetName()}, &devv1alpha1.Environment{})
+			if deleted := kerrors.IsNotFound(getErr); deleted != tt.wantDeleted {
+				t.Errorf("got environment deleted %t, want %t", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestHandleDeleting(t *testing.T) {
+	tests := []struct {
+		name           string
+		deleting       bool
+		withApp        bool
+		wantFinalizers []string
+		wantTeardown   *devv1alpha1.TeardownStatus
+		wantDeleted    bool
+	}{
+		{
+			name:           "environment that isn't deleted is left alone",
+			withApp:        true,
+			wantFinalizers: []string{EnvironmentFinalizer, LocalClusterFinalizer},
+		},
+		{
+			name:           "applications are deleted before the cluster",
+			deleting:       true,
+			withApp:        true,
+			wantFinalizers: []string{EnvironmentFinalizer, LocalClusterFinalizer},
+			wantTeardown: &devv1alpha1.TeardownStatus{
+				Step:    devv1alpha1.TeardownDeletingApplications,
+				Waiting: []string{"app"},
+			},
+		},
+		{
+			name:           "cluster is deleted once the applications are gone",
+			deleting:       true,
+			wantFinalizers: []string{},
+			wantDeleted:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment()
+			env.Finalizers = []string{EnvironmentFinalizer, LocalClusterFinalizer}
+			if tt.deleting {
+				now := metav1.Now()
+				env.DeletionTimestamp = &now
+			}
+			r := newTestReconciler(t, env)
+			if tt.withApp {
+				if err := r.Client.Create(context.Background(), newTestApp(t, r, env, "app", true)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			provider := &stubFinalizerProvider{}
+			pc := &phaseContext{env: env, provider: provider}
+
+			phase, err := r.handleDeleting(pc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if phase != devv1alpha1.PhaseDeleting {
+				t.Errorf("got phase %s, want %s", phase, devv1alpha1.PhaseDeleting)
+			}
+			if len(env.Finalizers) != len(tt.wantFinalizers) {
+				t.Errorf("got finalizers %v, want %v", env.Finalizers, tt.wantFinalizers)
+			}
+			if provider.deleted != tt.wantDeleted {
+				t.Errorf("got cluster deleted %t, want %t", provider.deleted, tt.wantDeleted)
+			}
+
+			teardown := env.Status.Teardown
+			if (teardown == nil) != (tt.wantTeardown == nil) {
+				t.Fatalf("got teardown %v, want %v", teardown, tt.wantTeardown)
+			}
+			if teardown != nil && (teardown.Step != tt.wantTeardown.Step || len(teardown.Waiting) != len(tt.wantTeardown.Waiting)) {
+				t.Errorf("got teardown %v, want %v", teardown, tt.wantTeardown)
+			}
+		})
+	}
+}
+
+// assertApplications checks the status references the argocd applications of the names and that they exist
+func assertApplications(t *testing.T, r *EnvironmentReconciler, env *devv1alpha1.Environment, names []string) {
+	t.Helper()
+	if len(env.Status.Applications) != len(names) {
+		t.Fatalf("got applications %v, want %v", env.Status.Applications, names)
+	}
+
+	for _, name := range names {
+		ref := applicationRef(env, name)
+		if ref.ApplicationName != env.ApplicationName(name) {
+			t.Errorf("got argocd application %s for %s, want %s", ref.ApplicationName, name, env.ApplicationName(name))
+		}
+
+		app := &argocdapplicationv1alpha1.Application{}
+		if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: testArgoCDNamespace, Name: ref.ApplicationName}, app); err != nil {
+			t.Errorf("could not get argocd application of %s: %v", name, err)
+		}
+	}
+}
+
+func TestHandleSleeping(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttlStart    time.Duration
+		extendTTL   string
+		suspendTTL  bool
+		wantPhase   devv1alpha1.EnvironmentPhase
+		wantWarning bool
+		wantDeleted bool
+	}{
+		{
+			name:      "sleeping environment far from its expiry isn't warned",
+			ttlStart:  time.Hour,
+			wantPhase: devv1alpha1.PhaseSleeping,
+		},
+		{
+			name:        "sleeping environment close to its expiry is warned",
+			ttlStart:    150 * time.Minute,
+			wantPhase:   devv1alpha1.PhaseSleeping,
+			wantWarning: true,
+		},
+		{
+			name:      "TTL of a sleeping environment is extended",
+			ttlStart:  150 * time.Minute,
+			extendTTL: "1d",
+			wantPhase: devv1alpha1.PhaseSleeping,
+		},
+		{
+			name:        "expired sleeping environment is deleted",
+			ttlStart:    4 * time.Hour,
+			wantPhase:   devv1alpha1.PhaseDeleting,
+			wantDeleted: true,
+		},
+		{
+			name:       "suspended TTL of a sleeping environment doesn't expire",
+			ttlStart:   4 * time.Hour,
+			suspendTTL: true,
+			wantPhase:  devv1alpha1.PhaseSleeping,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment()
+			env.Spec.TTL = "3h"
+			env.Status.Phase = devv1alpha1.PhaseSleeping
+			start := metav1.NewTime(time.Now().Add(-tt.ttlStart))
+			env.Status.TTLStartTimestamp = &start
+			env.Spec.SuspendTTL = tt.suspendTTL
+			if tt.extendTTL != "" {
+				env.Annotations = map[string]string{ExtendTTLAnnotation: tt.extendTTL}
+			}
+			r := newTestReconciler(t, env)
+			r.TTLWarnings = []time.Duration{time.Hour}
+			pc := &phaseContext{env: env, provider: &stubProvider{}}
+
+			phase, err := r.handleSleeping(pc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if phase != tt.wantPhase {
+				t.Errorf("got phase %s, want %s", phase, tt.wantPhase)
+			}
+			if warned := env.Status.LastTTLWarning != nil; warned != tt.wantWarning {
+				t.Errorf("got warning %t, want %t", warned, tt.wantWarning)
+			}
+			if tt.extendTTL != "" && env.Status.TTLExtension == nil {
+				t.Errorf("TTL wasn't extended")
+			}
+			if tt.suspendTTL && (env.Status.TTLSuspendedTimestamp == nil || env.Status.ExpiresAt != nil) {
+				t.Errorf("got TTL suspended at %v and expiring at %v, want suspended without an expiry",
+					env.Status.TTLSuspendedTimestamp, env.Status.ExpiresAt)
+			}
+
+			getErr := r.Client.Get(context.Background(), types.NamespacedName{Name: env.GetName()}, &devv1alpha1.Environment{})
+			if deleted := kerrors.IsNotFound(getErr); deleted != tt.wantDeleted {
+				t.Errorf("got environment deleted %t, want %t", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestWakingResumesSuspendedTTL(t *testing.T) {
+	env := newTestEnvironment()
+	env.Spec.TTL = "1d"
+	env.Spec.SuspendTTL = true
+	env.Status.Phase = devv1alpha1.PhaseSleeping
+	start := metav1.NewTime(time.Now().Add(-4 * time.Hour))
+	env.Status.TTLStartTimestamp = &start
+	r := newTestReconciler(t, env)
+	pc := &phaseContext{env: env, provider: &stubProvider{}}
+
+	if _, err := r.handleSleeping(pc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the environment slept with a suspended TTL for two hours
+	suspended := metav1.NewTime(env.Status.TTLSuspendedTimestamp.Add(-2 * time.Hour))
+	env.Status.TTLSuspendedTimestamp = &suspended
+	env.Spec.SuspendTTL = false
+
+	phase, err := r.handleReady(pc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if phase != devv1alpha1.PhaseReady {
+		t.Errorf("got phase %s, want %s", phase, devv1alpha1.PhaseReady)
+	}
+	// the TTL ran for two hours before it was suspended
+	if env.Status.ExpiresAt == nil || time.Until(env.Status.ExpiresAt.Time).Round(time.Minute) != 22*time.Hour {
+		t.Errorf("got expiry %v, want in 22 hours", env.Status.ExpiresAt)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// aksClusterProvider provisions Azure AKS clusters through crossplane's provider-azure
+type aksClusterProvider struct {
+	*EnvironmentReconciler
+}
+
+func (p *aksClusterProvider) FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error) {
+	gvk, _ := devv1alpha1.ProviderAKS.ClusterClassGroupVersionKind()
+	return p.fetchUnstructuredClusterClass(env, gvk)
+}
+
+func (p *aksClusterProvider) CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error) {
+	return p.ensureClusterClaim(env)
+}
+
+// CreateNodePools is a no-op for AKS, worker nodes are part of the AKSClusterClass spec template
+func (p *aksClusterProvider) CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error {
+	return nil
+}
+
+func (p *aksClusterProvider) IsClusterReady(env *devv1alpha1.Environment) bool {
+	return p.isClusterBound(env)
+}
+
+// AreNodePoolsReady returns true once the cluster is bound, the worker nodes are provisioned with the cluster
+func (p *aksClusterProvider) AreNodePoolsReady(env *devv1alpha1.Environment) bool {
+	return p.isClusterBound(env)
+}
@@ -0,0 +1,524 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	crossplaneruntime "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	crossplanegcpv1alpha1 "github.com/crossplane/provider-gcp/apis/container/v1alpha1"
+	crossplanegcpv1beta1 "github.com/crossplane/provider-gcp/apis/container/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// gkeDefaultMachineType is the machine type GKE creates nodes with when the node pool doesn't set one
+const gkeDefaultMachineType = "n1-standard-1"
+
+// gkeClusterProvider provisions GKE clusters and node pools through crossplane's provider-gcp
+type gkeClusterProvider struct {
+	*EnvironmentReconciler
+}
+
+func (p *gkeClusterProvider) FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error) {
+	k8class := &crossplanegcpv1beta1.GKEClusterClass{}
+	k8classNamespacedName := types.NamespacedName{
+		Name: env.Spec.ClusterClassLabel,
+	}
+	err := p.Client.Get(context.Background(), k8classNamespacedName, k8class)
+	if err != nil {
+		return nil, err
+	}
+
+	return k8class, nil
+}
+
+func (p *gkeClusterProvider) CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error) {
+	return p.ensureClusterClaim(env)
+}
+
+// CreateNodePools creates, updates and deletes the crossplane NodePools of the cluster to match the spec.
+// Node pools whose node configuration or node count changed are deleted and created again on the next
+// reconcile, a NodePoolRecreated event is recorded and they aren't ready until they are running again.
+func (p *gkeClusterProvider) CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error {
+	k8class, ok := class.(*crossplanegcpv1beta1.GKEClusterClass)
+	if !ok {
+		return fmt.Errorf("expected a GKEClusterClass but got %T", class)
+	}
+
+	desiredNames := map[string]bool{}
+	for _, nodePool := range desiredNodePools(env) {
+		name := nodePoolName(env, nodePool)
+		desiredNames[name] = true
+		if err := p.reconcileNodePool(env, k8class, managedResourceName, name, nodePool); err != nil {
+			p.Log.Error(err, "could not reconcile nodepool", "nodepool name", name)
+			return err
+		}
+	}
+
+	nodePools, listErr := p.ownedNodePools(env)
+	if listErr != nil {
+		return listErr
+	}
+	for i := range nodePools {
+		if desiredNames[nodePools[i].GetName()] {
+			continue
+		}
+
+		p.Log.Info("deleting nodepool removed from the environment", "nodepool name", nodePools[i].GetName())
+		if err := p.Client.Delete(context.Background(), &nodePools[i]); err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *gkeClusterProvider) reconcileNodePool(env *devv1alpha1.Environment, k8class *crossplanegcpv1beta1.GKEClusterClass, managedResourceName, name string, spec devv1alpha1.NodePoolSpec) error {
+	nodeConfig, nodeConfigErr := p.nodeConfig(spec)
+	if nodeConfigErr != nil {
+		return nodeConfigErr
+	}
+	desiredParameters := nodePoolParameters(spec, nodeConfig, managedResourceName)
+
+	gkeNodepool := &crossplanegcpv1alpha1.NodePool{}
+	getNodepoolErr := p.Client.Get(context.Background(), types.NamespacedName{Name: name}, gkeNodepool)
+	if getNodepoolErr != nil && !kerrors.IsNotFound(getNodepoolErr) {
+		return getNodepoolErr
+	}
+
+	if getNodepoolErr != nil {
+		return p.createNodePool(env, k8class, name, desiredParameters)
+	}
+
+	if !gkeNodepool.GetDeletionTimestamp().IsZero() {
+		// the nodepool is being recreated
+		return nil
+	}
+
+	if nodeConfigChanged(desiredParameters.Config, gkeNodepool.Spec.ForProvider.Config) {
+		return p.recreateNodePool(env, gkeNodepool, "node configuration")
+	}
+
+	if nodeCountChanged(desiredParameters, gkeNodepool.Spec.ForProvider) {
+		// crossplane can't resize a nodepool
+		return p.recreateNodePool(env, gkeNodepool, "node count")
+	}
+
+	if !autoscalingEqual(desiredParameters.Autoscaling, gkeNodepool.Spec.ForProvider.Autoscaling) {
+		p.Log.Info("updating autoscaling of the nodepool", "nodepool name", name)
+		patch := client.MergeFrom(gkeNodepool.DeepCopy())
+		gkeNodepool.Spec.ForProvider.Autoscaling = desiredParameters.Autoscaling
+		return p.Client.Patch(context.Background(), gkeNodepool, patch)
+	}
+
+	return nil
+}
+
+// recreateNodePool deletes a nodepool whose change crossplane can't apply, it is created again once it's gone.
+// The nodes are replaced, so the environment is told with an event.
+func (p *gkeClusterProvider) recreateNodePool(env *devv1alpha1.Environment, gkeNodepool *crossplanegcpv1alpha1.NodePool, change string) error {
+	p.Log.Info(fmt.Sprintf("%s of the nodepool changed, recreating it", change), "nodepool name", gkeNodepool.GetName())
+	if err := p.Client.Delete(context.Background(), gkeNodepool); err != nil {
+		return err
+	}
+
+	p.Recorder.Event(env, corev1.EventTypeWarning, "NodePoolRecreated",
+		fmt.Sprintf("nodepool %s is recreated because its %s changed, its nodes are replaced", gkeNodepool.GetName(), change))
+	return nil
+}
+
+func (p *gkeClusterProvider) createNodePool(env *devv1alpha1.Environment, k8class *crossplanegcpv1beta1.GKEClusterClass, name string, parameters crossplanegcpv1alpha1.NodePoolParameters) error {
+	p.Log.Info("creating nodepool", "nodepool name", name)
+
+	nodePool := &crossplanegcpv1alpha1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.CrossplaneNamespace,
+			Labels:    environmentLabels(env),
+		},
+		Spec: crossplanegcpv1alpha1.NodePoolSpec{
+			ResourceSpec: crossplaneruntime.ResourceSpec{
+				ProviderReference: &corev1.ObjectReference{
+					Name: k8class.SpecTemplate.ProviderReference.Name,
+				},
+				WriteConnectionSecretToReference: &crossplaneruntime.SecretReference{
+					Name:      fmt.Sprintf("%s-nodepool", name),
+					Namespace: p.CrossplaneNamespace,
+				},
+			},
+
+			ForProvider: parameters,
+		},
+	}
+	if err := ctrl.SetControllerReference(env, nodePool, p.Scheme); err != nil {
+		p.Log.Error(err, "could not set owner reference on gke nodepool")
+		return err
+	}
+
+	if err := p.Client.Create(context.Background(), nodePool); err != nil {
+		return err
+	}
+
+	p.Log.Info("created nodepool", "nodepool name", name)
+	return nil
+}
+
+// ownedNodePools returns the crossplane NodePools of the environment, including the
+// default pool of environments created before node pools were labelled
+func (p *gkeClusterProvider) ownedNodePools(env *devv1alpha1.Environment) ([]crossplanegcpv1alpha1.NodePool, error) {
+	nodePoolList := &crossplanegcpv1alpha1.NodePoolList{}
+	if err := p.Client.List(context.Background(), nodePoolList, client.MatchingLabels{EnvironmentNameLabel: env.GetName()}); err != nil {
+		return nil, err
+	}
+
+	nodePools := []crossplanegcpv1alpha1.NodePool{}
+	for _, nodePool := range nodePoolList.Items {
+		if metav1.IsControlledBy(&nodePool, env) {
+			nodePools = append(nodePools, nodePool)
+		}
+	}
+
+	legacyNodePool := &crossplanegcpv1alpha1.NodePool{}
+	err := p.Client.Get(context.Background(), types.NamespacedName{Name: env.Spec.ClusterName}, legacyNodePool)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && metav1.IsControlledBy(legacyNodePool, env) && legacyNodePool.GetLabels()[EnvironmentNameLabel] == "" {
+		nodePools = append(nodePools, *legacyNodePool)
+	}
+
+	return nodePools, nil
+}
+
+// desiredNodePools returns the node pools of the spec or the default node pool
+func desiredNodePools(env *devv1alpha1.Environment) []devv1alpha1.NodePoolSpec {
+	if len(env.Spec.NodePools) > 0 {
+		return env.Spec.NodePools
+	}
+
+	return []devv1alpha1.NodePoolSpec{{}}
+}
+
+// nodePoolName returns the name of the crossplane NodePool of a node pool of the spec.
+// The default node pool is named after the cluster like it always was.
+func nodePoolName(env *devv1alpha1.Environment, nodePool devv1alpha1.NodePoolSpec) string {
+	if nodePool.Name == "" {
+		return env.Spec.ClusterName
+	}
+
+	return fmt.Sprintf("%s-%s", env.Spec.ClusterName, nodePool.Name)
+}
+
+// nodeConfig returns the node configuration of a node pool with the defaults of its profile applied
+func (p *gkeClusterProvider) nodeConfig(spec devv1alpha1.NodePoolSpec) (devv1alpha1.NodeConfig, error) {
+	if spec.ProfileRef == "" {
+		return spec.NodeConfig, nil
+	}
+
+	profile := &devv1alpha1.NodePoolProfile{}
+	if err := p.Client.Get(context.Background(), types.NamespacedName{Name: spec.ProfileRef}, profile); err != nil {
+		return devv1alpha1.NodeConfig{}, fmt.Errorf("could not get node pool profile '%s': %v", spec.ProfileRef, err)
+	}
+
+	return mergeNodeConfig(profile.Spec, spec.NodeConfig), nil
+}
+
+// mergeNodeConfig overrides the profile with the fields the node pool sets, labels are merged
+func mergeNodeConfig(profile, nodePool devv1alpha1.NodeConfig) devv1alpha1.NodeConfig {
+	merged := *profile.DeepCopy()
+	if nodePool.MachineType != "" {
+		merged.MachineType = nodePool.MachineType
+	}
+	if nodePool.DiskSizeGb != nil {
+		merged.DiskSizeGb = nodePool.DiskSizeGb
+	}
+	if nodePool.DiskType != "" {
+		merged.DiskType = nodePool.DiskType
+	}
+	if nodePool.Preemptible != nil {
+		merged.Preemptible = nodePool.Preemptible
+	}
+	if len(nodePool.Labels) > 0 && merged.Labels == nil {
+		merged.Labels = map[string]string{}
+	}
+	for key, value := range nodePool.Labels {
+		merged.Labels[key] = value
+	}
+	if len(nodePool.Taints) > 0 {
+		merged.Taints = nodePool.Taints
+	}
+	if len(nodePool.OAuthScopes) > 0 {
+		merged.OAuthScopes = nodePool.OAuthScopes
+	}
+	if nodePool.ServiceAccount != "" {
+		merged.ServiceAccount = nodePool.ServiceAccount
+	}
+
+	return merged
+}
+
+// nodePoolParameters maps a node pool of the spec and its node configuration onto the parameters of a crossplane NodePool
+func nodePoolParameters(spec devv1alpha1.NodePoolSpec, nodeConfig devv1alpha1.NodeConfig, managedResourceName string) crossplanegcpv1alpha1.NodePoolParameters {
+	initialNodeCount := int64(2)
+	if spec.NodeCount != nil {
+		initialNodeCount = *spec.NodeCount
+	}
+
+	autoscaling := spec.Autoscaling
+	parameters := crossplanegcpv1alpha1.NodePoolParameters{
+		ClusterRef: &crossplanegcpv1alpha1.GKEClusterURIReferencerForNodePool{
+			GKEClusterURIReferencer: crossplanegcpv1beta1.GKEClusterURIReferencer{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: managedResourceName,
+				},
+			},
+		},
+		InitialNodeCount: &initialNodeCount,
+		Autoscaling: &crossplanegcpv1alpha1.NodePoolAutoscaling{
+			Enabled: &autoscaling,
+		},
+	}
+	if spec.Autoscaling {
+		parameters.Autoscaling.MinNodeCount = spec.MinNodes
+		parameters.Autoscaling.MaxNodeCount = spec.MaxNodes
+	}
+
+	if equality.Semantic.DeepEqual(nodeConfig, devv1alpha1.NodeConfig{}) {
+		return parameters
+	}
+
+	preemptible := boolValue(nodeConfig.Preemptible)
+	parameters.Config = &crossplanegcpv1alpha1.NodeConfig{
+		Preemptible: &preemptible,
+		Labels:      nodeConfig.Labels,
+		DiskSizeGb:  nodeConfig.DiskSizeGb,
+		OauthScopes: nodeConfig.OAuthScopes,
+	}
+	if nodeConfig.MachineType != "" {
+		machineType := nodeConfig.MachineType
+		parameters.Config.MachineType = &machineType
+	}
+	if nodeConfig.DiskType != "" {
+		diskType := nodeConfig.DiskType
+		parameters.Config.DiskType = &diskType
+	}
+	if nodeConfig.ServiceAccount != "" {
+		serviceAccount := nodeConfig.ServiceAccount
+		parameters.Config.ServiceAccount = &serviceAccount
+	}
+	for _, taint := range nodeConfig.Taints {
+		parameters.Config.Taints = append(parameters.Config.Taints, &crossplanegcpv1alpha1.NodeTaint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: gkeTaintEffect(taint.Effect),
+		})
+	}
+
+	return parameters
+}
+
+// gkeTaintEffect converts a kubernetes taint effect (NoSchedule) to the GKE one (NO_SCHEDULE)
+func gkeTaintEffect(effect corev1.TaintEffect) string {
+	switch effect {
+	case corev1.TaintEffectPreferNoSchedule:
+		return "PREFER_NO_SCHEDULE"
+	case corev1.TaintEffectNoExecute:
+		return "NO_EXECUTE"
+	}
+
+	return "NO_SCHEDULE"
+}
+
+// nodeConfigChanged compares the fields of the node configuration the spec sets.
+// Crossplane late initializes the other fields from GKE, they'd always differ.
+func nodeConfigChanged(desired, live *crossplanegcpv1alpha1.NodeConfig) bool {
+	if desired == nil {
+		desired = &crossplanegcpv1alpha1.NodeConfig{}
+	}
+	if live == nil {
+		live = &crossplanegcpv1alpha1.NodeConfig{}
+	}
+
+	if stringChanged(desired.MachineType, live.MachineType) || stringChanged(desired.DiskType, live.DiskType) ||
+		stringChanged(desired.ServiceAccount, live.ServiceAccount) {
+		return true
+	}
+	if desired.DiskSizeGb != nil && int64Value(desired.DiskSizeGb) != int64Value(live.DiskSizeGb) {
+		return true
+	}
+	if len(desired.OauthScopes) > 0 && !sets.NewString(desired.OauthScopes...).Equal(sets.NewString(live.OauthScopes...)) {
+		return true
+	}
+	if boolValue(desired.Preemptible) != boolValue(live.Preemptible) {
+		return true
+	}
+	if len(desired.Labels) != len(live.Labels) || len(desired.Taints) != len(live.Taints) {
+		return true
+	}
+	for key, value := range desired.Labels {
+		if liveValue, ok := live.Labels[key]; !ok || liveValue != value {
+			return true
+		}
+	}
+	for i := range desired.Taints {
+		if *desired.Taints[i] != *live.Taints[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stringChanged reports whether a field the spec sets differs from the live one
+func stringChanged(desired, live *string) bool {
+	return desired != nil && (live == nil || *desired != *live)
+}
+
+// nodeCountChanged returns true when a nodepool without autoscaling has to be resized
+func nodeCountChanged(desired, live crossplanegcpv1alpha1.NodePoolParameters) bool {
+	if boolValue(desired.Autoscaling.Enabled) {
+		return false
+	}
+
+	return int64Value(desired.InitialNodeCount) != int64Value(live.InitialNodeCount)
+}
+
+func autoscalingEqual(desired, live *crossplanegcpv1alpha1.NodePoolAutoscaling) bool {
+	if live == nil {
+		return !boolValue(desired.Enabled)
+	}
+	if boolValue(desired.Enabled) != boolValue(live.Enabled) {
+		return false
+	}
+	if !boolValue(desired.Enabled) {
+		return true
+	}
+
+	return int64Value(desired.MinNodeCount) == int64Value(live.MinNodeCount) &&
+		int64Value(desired.MaxNodeCount) == int64Value(live.MaxNodeCount)
+}
+
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
+
+func int64Value(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+
+	return *i
+}
+
+// Machines counts the nodes of the node pools of the spec, autoscaling pools with their maximum size.
+// Pools without a machine type get the default machine type of GKE.
+func (p *gkeClusterProvider) Machines(env *devv1alpha1.Environment) (map[string]int64, error) {
+	machines := map[string]int64{}
+	for _, nodePool := range desiredNodePools(env) {
+		nodeConfig, err := p.nodeConfig(nodePool)
+		if err != nil {
+			return nil, err
+		}
+
+		machineType := nodeConfig.MachineType
+		if machineType == "" {
+			machineType = gkeDefaultMachineType
+		}
+		if boolValue(nodeConfig.Preemptible) {
+			machineType += PreemptiblePriceSuffix
+		}
+
+		count := int64(2)
+		if nodePool.NodeCount != nil {
+			count = *nodePool.NodeCount
+		}
+		if nodePool.Autoscaling && nodePool.MaxNodes != nil {
+			count = *nodePool.MaxNodes
+		}
+		machines[machineType] += count
+	}
+
+	return machines, nil
+}
+
+// SleepCluster deletes the node pools of the cluster, provider-gcp can't resize them to zero.
+// The cluster itself is kept, so waking up only has to wait for the node pools.
+func (p *gkeClusterProvider) SleepCluster(env *devv1alpha1.Environment) error {
+	_, err := p.DeleteNodePools(env)
+	return err
+}
+
+func (p *gkeClusterProvider) DeleteNodePools(env *devv1alpha1.Environment) ([]string, error) {
+	nodePools, err := p.ownedNodePools(env)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := []string{}
+	for i := range nodePools {
+		remaining = append(remaining, nodePools[i].GetName())
+		if !nodePools[i].GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		p.Log.Info("deleting nodepool", "nodepool name", nodePools[i].GetName())
+		if err := p.Client.Delete(context.Background(), &nodePools[i]); err != nil && !kerrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return remaining, nil
+}
+
+// WakeCluster does nothing, the node pools are created again in the ProvisioningNodePool phase
+func (p *gkeClusterProvider) WakeCluster(env *devv1alpha1.Environment) error {
+	return nil
+}
+
+func (p *gkeClusterProvider) IsClusterReady(env *devv1alpha1.Environment) bool {
+	return p.isClusterBound(env)
+}
+
+func (p *gkeClusterProvider) AreNodePoolsReady(env *devv1alpha1.Environment) bool {
+	for _, nodePool := range desiredNodePools(env) {
+		name := nodePoolName(env, nodePool)
+		gkeNodepool := &crossplanegcpv1alpha1.NodePool{}
+		if err := p.Client.Get(context.Background(), types.NamespacedName{Name: name}, gkeNodepool); err != nil {
+			p.Log.Info("nodepool is not ready yet", "nodepool name", name)
+			return false
+		}
+
+		// a nodepool being recreated still runs its old nodes
+		if !gkeNodepool.GetDeletionTimestamp().IsZero() || gkeNodepool.Status.AtProvider.Status != crossplanegcpv1alpha1.NodePoolStateRunning {
+			p.Log.Info("nodepool is not ready yet", "nodepool name", name)
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	providergcpapis "github.com/crossplane/provider-gcp/apis"
+	crossplanegcpv1alpha1 "github.com/crossplane/provider-gcp/apis/container/v1alpha1"
+	crossplanegcpv1beta1 "github.com/crossplane/provider-gcp/apis/container/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+func TestNodePoolRecreation(t *testing.T) {
+	env := newTestEnvironment()
+	r := newTestReconciler(t, env)
+	if err := providergcpapis.AddToScheme(r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	provider := &gkeClusterProvider{r}
+
+	spec := devv1alpha1.NodePoolSpec{}
+	nodeConfig, err := provider.nodeConfig(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parameters := nodePoolParameters(spec, nodeConfig, "test-env-cluster")
+	// the environment asks for another node count than the running nodepool has
+	nodeCount := *parameters.InitialNodeCount + 1
+	parameters.InitialNodeCount = &nodeCount
+	nodePool := &crossplanegcpv1alpha1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-env"},
+		Spec:       crossplanegcpv1alpha1.NodePoolSpec{ForProvider: parameters},
+	}
+	nodePool.Status.AtProvider.Status = crossplanegcpv1alpha1.NodePoolStateRunning
+	if err := r.Client.Create(context.Background(), nodePool); err != nil {
+		t.Fatal(err)
+	}
+	if !provider.AreNodePoolsReady(env) {
+		t.Fatalf("running nodepool isn't ready")
+	}
+
+	if err := provider.reconcileNodePool(env, &crossplanegcpv1beta1.GKEClusterClass{}, "test-env-cluster", "test-env", spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	getErr := r.Client.Get(context.Background(), types.NamespacedName{Name: "test-env"}, &crossplanegcpv1alpha1.NodePool{})
+	if getErr == nil {
+		t.Errorf("nodepool with another node count wasn't deleted")
+	}
+	select {
+	case event := <-r.Recorder.(*record.FakeRecorder).Events:
+		if !strings.Contains(event, "NodePoolRecreated") {
+			t.Errorf("got event %s, want NodePoolRecreated", event)
+		}
+	default:
+		t.Errorf("recreation of the nodepool wasn't recorded")
+	}
+
+	// crossplane deletes the nodepool in the cloud before the object is gone
+	now := metav1.Now()
+	nodePool.ResourceVersion = ""
+	nodePool.DeletionTimestamp = &now
+	if err := r.Client.Create(context.Background(), nodePool); err != nil {
+		t.Fatal(err)
+	}
+	if provider.AreNodePoolsReady(env) {
+		t.Errorf("nodepool being deleted is ready")
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	crossplaneruntime "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+const (
+	// LocalClusterFinalizer makes sure local clusters are deleted along with the environment
+	// (they aren't kubernetes objects, so owner references can't garbage collect them)
+	LocalClusterFinalizer = "dev-environment/finalizers.localcluster.vadasambar.github.io"
+)
+
+// localClusterTool wraps the CLI used to manage a local cluster
+type localClusterTool interface {
+	// Command is the name of the CLI
+	Command() string
+	Exists(name string) (bool, error)
+	Create(name string) error
+	Delete(name string) error
+	Kubeconfig(name string) ([]byte, error)
+}
+
+// localClusterProvider provisions clusters on the machine the controller runs on
+// using kind or k3d instead of a cloud provider. The kubeconfig of the
+// cluster is written to a secret shaped like the crossplane connection secret,
+// so argocd applications can target it the same way as a cloud cluster.
+// The respective CLI and docker have to be available on the machine the
+// controller runs on, which is only the case when it runs outside of the cluster (e.g., `make run`).
+type localClusterProvider struct {
+	*EnvironmentReconciler
+	tool localClusterTool
+}
+
+// FetchClusterClass returns nil, local clusters don't use a cluster class
+func (p *localClusterProvider) FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error) {
+	return nil, nil
+}
+
+func (p *localClusterProvider) CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error) {
+	// the connection secret is only written once the cluster exists, so the CLI isn't run on every reconcile
+	if p.IsClusterReady(env) {
+		return env.Spec.ClusterName, nil
+	}
+
+	if err := p.lookPath(); err != nil {
+		return "", err
+	}
+
+	exists, err := p.tool.Exists(env.Spec.ClusterName)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		p.Log.Info("creating local cluster", "cluster-name", env.Spec.ClusterName, "provider", env.Spec.Provider)
+		if err := p.tool.Create(env.Spec.ClusterName); err != nil {
+			p.Log.Error(err, "could not create local cluster", "cluster-name", env.Spec.ClusterName)
+			return "", err
+		}
+		p.Log.Info("created local cluster", "cluster-name", env.Spec.ClusterName)
+	}
+
+	kubeconfig, err := p.tool.Kubeconfig(env.Spec.ClusterName)
+	if err != nil {
+		p.Log.Error(err, "could not get kubeconfig of local cluster", "cluster-name", env.Spec.ClusterName)
+		return "", err
+	}
+	if err := p.writeConnectionSecret(env, kubeconfig); err != nil {
+		return "", err
+	}
+
+	return env.Spec.ClusterName, nil
+}
+
+// CreateNodePools is a no-op, local clusters come with their nodes
+func (p *localClusterProvider) CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error {
+	return nil
+}
+
+func (p *localClusterProvider) IsClusterReady(env *devv1alpha1.Environment) bool {
+	return p.connectionSecretReady(env)
+}
+
+// AreNodePoolsReady returns true once the cluster is ready, local clusters come with their nodes
+func (p *localClusterProvider) AreNodePoolsReady(env *devv1alpha1.Environment) bool {
+	return p.IsClusterReady(env)
+}
+
+func (p *localClusterProvider) Finalizer() string {
+	return LocalClusterFinalizer
+}
+
+func (p *localClusterProvider) DeleteCluster(env *devv1alpha1.Environment) error {
+	if err := p.lookPath(); err != nil {
+		return err
+	}
+
+	exists, err := p.tool.Exists(env.Spec.ClusterName)
+	if err != nil || !exists {
+		return err
+	}
+
+	p.Log.Info("deleting local cluster", "cluster-name", env.Spec.ClusterName, "provider", env.Spec.Provider)
+	return p.tool.Delete(env.Spec.ClusterName)
+}
+
+// lookPath returns an error if the CLI of the provider isn't installed, e.g., because the controller runs in
+// the distroless image instead of on the machine the local clusters are created on
+func (p *localClusterProvider) lookPath() error {
+	if _, err := exec.LookPath(p.tool.Command()); err != nil {
+		return fmt.Errorf("the %s CLI isn't available to the controller, local providers only work when the controller runs on the machine the clusters are created on (e.g., make run): %v",
+			p.tool.Command(), err)
+	}
+
+	return nil
+}
+
+// connectionSecretReady returns true once the connection secret of the environment holds a kubeconfig
+func (r *EnvironmentReconciler) connectionSecretReady(env *devv1alpha1.Environment) bool {
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: r.CrossplaneNamespace, Name: env.Spec.ClusterName}, secret); err != nil {
+		r.Log.Info("connection secret of the cluster is not ready yet", "cluster-name", env.Spec.ClusterName)
+		return false
+	}
+
+	return metav1.IsControlledBy(secret, env) && len(secret.Data[crossplaneruntime.ResourceCredentialsSecretKubeconfigKey]) > 0
+}
+
+// writeConnectionSecret writes the kubeconfig of a cluster the controller provisions without crossplane
+// to a secret with the same keys crossplane uses for cluster connection secrets
+func (r *EnvironmentReconciler) writeConnectionSecret(env *devv1alpha1.Environment, kubeconfig []byte) error {
+	data, err := connectionDetailsFromKubeconfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      env.Spec.ClusterName,
+			Namespace: r.CrossplaneNamespace,
+			Labels:    environmentLabels(env),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+	if err := ctrl.SetControllerReference(env, secret, r.Scheme); err != nil {
+		r.Log.Error(err, "failed to set owner reference on connection secret")
+		return err
+	}
+
+	existing := &corev1.Secret{}
+	getErr := r.Client.Get(context.Background(), types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, existing)
+	if getErr != nil && !kerrors.IsNotFound(getErr) {
+		return getErr
+	}
+
+	if getErr != nil {
+		return r.Client.Create(context.Background(), secret)
+	}
+
+	if !metav1.IsControlledBy(existing, env) {
+		return fmt.Errorf("secret '%s/%s' already exists and doesn't belong to environment '%s'", existing.GetNamespace(), existing.GetName(), env.GetName())
+	}
+	if equality.Semantic.DeepEqual(existing.Data, secret.Data) {
+		return nil
+	}
+
+	existing.Data = secret.Data
+	return r.Client.Update(context.Background(), existing)
+}
+
+// connectionDetailsFromKubeconfig converts a kubeconfig into crossplane connection secret keys
+func connectionDetailsFromKubeconfig(kubeconfig []byte) (map[string][]byte, error) {
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no current context")
+	}
+	cluster, ok := config.Clusters[kubeContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig has no cluster '%s'", kubeContext.Cluster)
+	}
+
+	data := map[string][]byte{
+		crossplaneruntime.ResourceCredentialsSecretEndpointKey:   []byte(cluster.Server),
+		crossplaneruntime.ResourceCredentialsSecretCAKey:         cluster.CertificateAuthorityData,
+		crossplaneruntime.ResourceCredentialsSecretKubeconfigKey: kubeconfig,
+	}
+
+	if authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]; ok {
+		data[crossplaneruntime.ResourceCredentialsSecretClientCertKey] = authInfo.ClientCertificateData
+		data[crossplaneruntime.ResourceCredentialsSecretClientKeyKey] = authInfo.ClientKeyData
+		data[crossplaneruntime.ResourceCredentialsSecretUserKey] = []byte(authInfo.Username)
+		data[crossplaneruntime.ResourceCredentialsSecretPasswordKey] = []byte(authInfo.Password)
+		data[crossplaneruntime.ResourceCredentialsSecretTokenKey] = []byte(authInfo.Token)
+	}
+
+	return data, nil
+}
+
+// runCommand runs a CLI command and returns its stdout
+func runCommand(name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// containsLine returns true if one of the lines of out is exactly name
+func containsLine(out []byte, name string) bool {
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kindTool manages clusters with https://kind.sigs.k8s.io
+type kindTool struct{}
+
+func (kindTool) Command() string {
+	return "kind"
+}
+
+func (kindTool) Exists(name string) (bool, error) {
+	out, err := runCommand("kind", "get", "clusters")
+	if err != nil {
+		return false, err
+	}
+
+	return containsLine(out, name), nil
+}
+
+func (kindTool) Create(name string) error {
+	_, err := runCommand("kind", "create", "cluster", "--name", name)
+	return err
+}
+
+func (kindTool) Delete(name string) error {
+	_, err := runCommand("kind", "delete", "cluster", "--name", name)
+	return err
+}
+
+// Kubeconfig returns the kubeconfig with the cluster's address on the docker network,
+// which is reachable from an argocd running in another kind cluster
+func (kindTool) Kubeconfig(name string) ([]byte, error) {
+	return runCommand("kind", "get", "kubeconfig", "--internal", "--name", name)
+}
+
+// k3dTool manages clusters with https://k3d.io
+type k3dTool struct{}
+
+func (k3dTool) Command() string {
+	return "k3d"
+}
+
+func (k3dTool) Exists(name string) (bool, error) {
+	out, err := runCommand("k3d", "cluster", "list", "--no-headers")
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (k3dTool) Create(name string) error {
+	_, err := runCommand("k3d", "cluster", "create", name, "--wait")
+	return err
+}
+
+func (k3dTool) Delete(name string) error {
+	_, err := runCommand("k3d", "cluster", "delete", name)
+	return err
+}
+
+func (k3dTool) Kubeconfig(name string) ([]byte, error) {
+	return runCommand("k3d", "kubeconfig", "get", name)
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+const (
+	// HostClusterServer is the argocd destination server of the cluster argocd runs in
+	HostClusterServer = "https://kubernetes.default.svc"
+
+	isolationObjectName = "dev-environment"
+
+	// namespaceNameLabel is set to the name of every namespace by kubernetes
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+)
+
+// namespaceClusterProvider isolates an environment in a namespace of the host cluster
+// guarded by a resource quota, rbac and a network policy instead of provisioning a cluster.
+// When vcluster is set, a virtual cluster is run in that namespace and applications are
+// deployed to it instead of the namespace.
+type namespaceClusterProvider struct {
+	*EnvironmentReconciler
+	vcluster *vclusterProvider
+}
+
+// namespace returns the host namespace of the environment
+func (p *namespaceClusterProvider) namespace(env *devv1alpha1.Environment) string {
+	if p.vcluster != nil {
+		return vclusterNamespace(env.Spec.ClusterName)
+	}
+
+	return env.Spec.ClusterName
+}
+
+// FetchClusterClass returns nil, namespaces don't use a cluster class
+func (p *namespaceClusterProvider) FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error) {
+	return nil, nil
+}
+
+func (p *namespaceClusterProvider) CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error) {
+	namespace := p.namespace(env)
+	spec := env.Spec.NamespaceIsolation
+	if spec == nil {
+		spec = &devv1alpha1.NamespaceIsolationSpec{}
+	}
+
+	hard := spec.ResourceQuota
+	if len(hard) == 0 {
+		hard = corev1.ResourceList{
+			corev1.ResourcePods: resource.MustParse("50"),
+		}
+	}
+
+	// only allow traffic from pods of the same namespace, argocd has to reach the api server of a vcluster
+	ingressPeers := []networkingv1.NetworkPolicyPeer{
+		{PodSelector: &metav1.LabelSelector{}},
+	}
+	if p.vcluster != nil {
+		ingressPeers = append(ingressPeers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{namespaceNameLabel: p.ArgoCDNamespace},
+			},
+		})
+	}
+
+	objects := []runtime.Object{
+		&corev1.Namespace{
+			ObjectMeta: p.isolationObjectMeta(env, namespace, ""),
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: p.isolationObjectMeta(env, isolationObjectName, namespace),
+			Spec: corev1.ResourceQuotaSpec{
+				Hard: hard,
+			},
+		},
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: p.isolationObjectMeta(env, isolationObjectName, namespace),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{From: ingressPeers},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		},
+	}
+
+	if len(spec.Members) > 0 {
+		objects = append(objects, &rbacv1.RoleBinding{
+			ObjectMeta: p.isolationObjectMeta(env, isolationObjectName, namespace),
+			Subjects:   spec.Members,
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     "edit",
+			},
+		})
+	}
+
+	for _, obj := range objects {
+		if err := p.createOwned(env, obj.(ownedObject)); err != nil {
+			p.Log.Error(err, "could not create object for namespace isolation", "namespace", namespace)
+			return "", err
+		}
+	}
+
+	if p.vcluster != nil {
+		return p.vcluster.CreateClusterClaim(env, class)
+	}
+
+	return namespace, nil
+}
+
+// CreateNodePools is a no-op, namespaces are scheduled on the host cluster's nodes
+func (p *namespaceClusterProvider) CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error {
+	return nil
+}
+
+func (p *namespaceClusterProvider) IsClusterReady(env *devv1alpha1.Environment) bool {
+	namespace := &corev1.Namespace{}
+	if err := p.Client.Get(context.Background(), types.NamespacedName{Name: p.namespace(env)}, namespace); err != nil {
+		p.Log.Info("namespace is not ready yet", "namespace", p.namespace(env))
+		return false
+	}
+
+	if namespace.Status.Phase != corev1.NamespaceActive {
+		return false
+	}
+
+	if p.vcluster != nil {
+		return p.vcluster.IsClusterReady(env)
+	}
+
+	return true
+}
+
+// AreNodePoolsReady returns true once the namespace is ready, namespaces use the host cluster's nodes
+func (p *namespaceClusterProvider) AreNodePoolsReady(env *devv1alpha1.Environment) bool {
+	return p.IsClusterReady(env)
+}
+
+// ownedObject is an object the controller creates for an environment
+type ownedObject interface {
+	runtime.Object
+	metav1.Object
+}
+
+// createOwned creates the object owned by the environment. An object that already exists has to belong
+// to the environment, e.g., namespace isolation mustn't take over the argocd or kube-system namespace.
+func (r *EnvironmentReconciler) createOwned(env *devv1alpha1.Environment, obj ownedObject) error {
+	if err := ctrl.SetControllerReference(env, obj, r.Scheme); err != nil {
+		r.Log.Error(err, "failed to set owner reference")
+		return err
+	}
+
+	createErr := r.Client.Create(context.Background(), obj)
+	if createErr == nil || !kerrors.IsAlreadyExists(createErr) {
+		return createErr
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	newObj, err := r.Scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	existing := newObj.(ownedObject)
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, existing); err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(existing, env) {
+		return fmt.Errorf("%s '%s' already exists and doesn't belong to environment '%s'", strings.ToLower(gvk.Kind), obj.GetName(), env.GetName())
+	}
+
+	return nil
+}
+
+func (p *namespaceClusterProvider) isolationObjectMeta(env *devv1alpha1.Environment, name, namespace string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels:    environmentLabels(env),
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	crossplaneruntime "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: vcluster
+contexts:
+- name: vcluster
+  context:
+    cluster: vcluster
+    user: vcluster
+clusters:
+- name: vcluster
+  cluster:
+    server: https://test-env.vcluster-test-env.svc
+users:
+- name: vcluster
+  user:
+    token: secret-token
+`
+
+func TestNamespaceIsolationOwnership(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *corev1.Namespace
+		wantErr  bool
+	}{
+		{
+			name: "namespace is created",
+		},
+		{
+			name:     "namespace of another owner isn't taken over",
+			existing: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-env"}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvironment()
+			env.Spec.Isolation = devv1alpha1.IsolationNamespace
+			r := newTestReconciler(t, env)
+			if tt.existing != nil {
+				if err := r.Client.Create(context.Background(), tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			provider := &namespaceClusterProvider{r, nil}
+
+			_, err := provider.CreateClusterClaim(env, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			// a second reconcile finds the objects it created
+			if !tt.wantErr {
+				if _, err := provider.CreateClusterClaim(env, nil); err != nil {
+					t.Errorf("unexpected error on the second reconcile: %v", err)
+				}
+			}
+
+			quota := &corev1.ResourceQuota{}
+			getErr := r.Client.Get(context.Background(), types.NamespacedName{Namespace: "test-env", Name: isolationObjectName}, quota)
+			if created := getErr == nil; created == tt.wantErr {
+				t.Errorf("got resource quota created %t, want %t", created, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVClusterProvider(t *testing.T) {
+	env := newTestEnvironment()
+	env.Spec.Provider = devv1alpha1.ProviderVCluster
+	r := newTestReconciler(t, env)
+	provider := &vclusterProvider{r}
+
+	name, err := provider.CreateClusterClaim(env, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "" || provider.IsClusterReady(env) {
+		t.Errorf("vcluster is ready before it wrote its kubeconfig")
+	}
+
+	app := &argocdapplicationv1alpha1.Application{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: testArgoCDNamespace, Name: "vcluster-test-env"}, app); err != nil {
+		t.Fatalf("could not get the argocd application of the vcluster: %v", err)
+	}
+	if app.Spec.Source.Chart != VClusterChart || app.Spec.Destination.Namespace != "vcluster-test-env" || !metav1.IsControlledBy(app, env) {
+		t.Errorf("got argocd application %v, want the vcluster chart deployed to vcluster-test-env", app.Spec)
+	}
+	if _, ok := app.GetLabels()[EnvironmentNameLabel]; ok {
+		t.Errorf("argocd application of the vcluster is labelled like the applications of the environment")
+	}
+
+	kubeconfigSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vcluster-test-env", Name: "vc-test-env"},
+		Data:       map[string][]byte{"config": []byte(testKubeconfig)},
+	}
+	if err := r.Client.Create(context.Background(), kubeconfigSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err = provider.CreateClusterClaim(env, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != env.Spec.ClusterName || !provider.IsClusterReady(env) {
+		t.Errorf("vcluster isn't ready once it wrote its kubeconfig")
+	}
+
+	connectionSecret := &corev1.Secret{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: r.CrossplaneNamespace, Name: env.Spec.ClusterName}, connectionSecret); err != nil {
+		t.Fatalf("could not get the connection secret: %v", err)
+	}
+	if endpoint := string(connectionSecret.Data[crossplaneruntime.ResourceCredentialsSecretEndpointKey]); endpoint != "https://test-env.vcluster-test-env.svc" {
+		t.Errorf("got endpoint %s, want the service of the vcluster", endpoint)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+const (
+	// VClusterChartRepoURL is the helm repository of the vcluster chart
+	VClusterChartRepoURL = "https://charts.loft.sh"
+	// VClusterChart is the chart virtual clusters are deployed with
+	VClusterChart = "vcluster"
+	// VClusterChartVersion is the version of the vcluster chart
+	VClusterChartVersion = "0.19.5"
+)
+
+// vclusterProvider runs a virtual cluster (https://www.vcluster.com) in its own `vcluster-<name>` namespace
+// of the host cluster. The vcluster chart is deployed through an argocd application, and the kubeconfig it
+// writes to the `vc-<name>` secret is copied to a secret shaped like the crossplane connection secret.
+// The namespace, the argocd application and the secret are owned by the environment, so they're garbage
+// collected with it.
+type vclusterProvider struct {
+	*EnvironmentReconciler
+}
+
+// vclusterNamespace returns the host namespace the virtual cluster of the cluster name runs in
+func vclusterNamespace(clusterName string) string {
+	return fmt.Sprintf("vcluster-%s", clusterName)
+}
+
+// FetchClusterClass returns nil, virtual clusters don't use a cluster class
+func (p *vclusterProvider) FetchClusterClass(env *devv1alpha1.Environment) (runtime.Object, error) {
+	return nil, nil
+}
+
+func (p *vclusterProvider) CreateClusterClaim(env *devv1alpha1.Environment, class runtime.Object) (string, error) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   vclusterNamespace(env.Spec.ClusterName),
+			Labels: environmentLabels(env),
+		},
+	}
+	if err := p.createOwned(env, namespace); err != nil {
+		p.Log.Error(err, "could not create the namespace of the vcluster", "namespace", namespace.GetName())
+		return "", err
+	}
+
+	if err := p.ensureVClusterApp(env); err != nil {
+		return "", err
+	}
+
+	// the vcluster writes its kubeconfig once it's running
+	kubeconfigSecret := &corev1.Secret{}
+	err := p.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.GetName(), Name: fmt.Sprintf("vc-%s", env.Spec.ClusterName)}, kubeconfigSecret)
+	if kerrors.IsNotFound(err) || (err == nil && len(kubeconfigSecret.Data["config"]) == 0) {
+		p.Log.Info("vcluster is not running yet", "cluster-name", env.Spec.ClusterName)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.writeConnectionSecret(env, kubeconfigSecret.Data["config"]); err != nil {
+		return "", err
+	}
+
+	return env.Spec.ClusterName, nil
+}
+
+// CreateNodePools is a no-op, virtual clusters are scheduled on the host cluster's nodes
+func (p *vclusterProvider) CreateNodePools(env *devv1alpha1.Environment, class runtime.Object, managedResourceName string) error {
+	return nil
+}
+
+func (p *vclusterProvider) IsClusterReady(env *devv1alpha1.Environment) bool {
+	return p.connectionSecretReady(env)
+}
+
+// AreNodePoolsReady returns true once the virtual cluster is ready, it uses the host cluster's nodes
+func (p *vclusterProvider) AreNodePoolsReady(env *devv1alpha1.Environment) bool {
+	return p.IsClusterReady(env)
+}
+
+// ensureVClusterApp creates the argocd application of the vcluster chart and corrects its drift.
+// It isn't labelled with the environment name, so it isn't pruned or deleted with the applications
+// deployed to the virtual cluster.
+func (p *vclusterProvider) ensureVClusterApp(env *devv1alpha1.Environment) error {
+	namespace := vclusterNamespace(env.Spec.ClusterName)
+	server := fmt.Sprintf("%s.%s.svc", env.Spec.ClusterName, namespace)
+	desiredApp := &argocdapplicationv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespace,
+			Namespace: p.ArgoCDNamespace,
+			Labels: map[string]string{
+				EnvironmentUIDLabel: string(env.GetUID()),
+			},
+		},
+		Spec: argocdapplicationv1alpha1.ApplicationSpec{
+			Source: argocdapplicationv1alpha1.ApplicationSource{
+				RepoURL:        VClusterChartRepoURL,
+				Chart:          VClusterChart,
+				TargetRevision: VClusterChartVersion,
+				Helm: &argocdapplicationv1alpha1.ApplicationSourceHelm{
+					ReleaseName: env.Spec.ClusterName,
+					// the kubeconfig points at the service of the vcluster, so argocd can reach it from the host cluster
+					Values: fmt.Sprintf("syncer:\n  extraArgs:\n  - --tls-san=%s\n  - --out-kube-config-server=https://%s\n", server, server),
+				},
+			},
+			Destination: argocdapplicationv1alpha1.ApplicationDestination{
+				Namespace: namespace,
+				Server:    HostClusterServer,
+			},
+			Project: "default",
+			SyncPolicy: &argocdapplicationv1alpha1.SyncPolicy{
+				Automated: &argocdapplicationv1alpha1.SyncPolicyAutomated{
+					Prune:    true,
+					SelfHeal: true,
+				},
+			},
+		},
+	}
+
+	app := &argocdapplicationv1alpha1.Application{}
+	err := p.Client.Get(context.Background(), types.NamespacedName{Namespace: p.ArgoCDNamespace, Name: desiredApp.GetName()}, app)
+	if kerrors.IsNotFound(err) {
+		_, err = p.createArgoCDApp(env, desiredApp)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(app, env) {
+		return fmt.Errorf("argocd application '%s' already exists and doesn't belong to environment '%s'", app.GetName(), env.GetName())
+	}
+	_, err = p.patchArgoCDApp(app, desiredApp)
+	return err
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	computev1alpha1 "github.com/crossplane/crossplane/apis/compute/v1alpha1"
+	crossplanegcpv1alpha1 "github.com/crossplane/provider-gcp/apis/container/v1alpha1"
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// orphanGracePeriod keeps the reaper away from objects whose environment might not be in the cache yet
+const orphanGracePeriod = 5 * time.Minute
+
+// OrphanReaper periodically deletes the KubernetesCluster claims, NodePools and argocd applications
+// the controller created for environments that don't exist anymore, e.g., because the controller
+// crashed while creating them or the environment was deleted with `--cascade=false`
+type OrphanReaper struct {
+	*EnvironmentReconciler
+	// Interval is the time between two runs of the reaper
+	Interval time.Duration
+	// DryRun only reports orphans through the log and events instead of deleting them
+	DryRun bool
+}
+
+// orphanCandidate is an object the controller might have created for an environment
+type orphanCandidate interface {
+	runtime.Object
+	metav1.Object
+}
+
+// Start runs the reaper every interval until stop is closed
+func (r *OrphanReaper) Start(stop <-chan struct{}) error {
+	r.Log.Info("starting orphan reaper", "interval", r.Interval, "dry-run", r.DryRun)
+	wait.Until(func() {
+		if err := r.reap(); err != nil {
+			r.Log.Error(err, "could not reap orphaned objects")
+		}
+	}, r.Interval, stop)
+
+	return nil
+}
+
+// reap deletes or reports the objects of environments that don't exist anymore
+func (r *OrphanReaper) reap() error {
+	environments := &devv1alpha1.EnvironmentList{}
+	if err := r.Client.List(context.Background(), environments); err != nil {
+		return err
+	}
+	uids := map[types.UID]bool{}
+	for _, env := range environments.Items {
+		uids[env.GetUID()] = true
+	}
+
+	candidates, err := r.candidates()
+	if err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		uid, ok := environmentUID(candidate)
+		if !ok || uids[uid] || time.Since(candidate.GetCreationTimestamp().Time) < orphanGracePeriod ||
+			!candidate.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		kind := "unknown"
+		if gvk, err := apiutil.GVKForObject(candidate, r.Scheme); err == nil {
+			kind = gvk.Kind
+		}
+		if r.DryRun {
+			r.Log.Info("found orphaned object, not deleting it in dry-run mode", "kind", kind, "name", candidate.GetName(), "namespace", candidate.GetNamespace(), "environment-uid", uid)
+			r.Recorder.Event(candidate, corev1.EventTypeWarning, "Orphaned", fmt.Sprintf("environment %s doesn't exist anymore", uid))
+			continue
+		}
+
+		r.Log.Info("deleting orphaned object", "kind", kind, "name", candidate.GetName(), "namespace", candidate.GetNamespace(), "environment-uid", uid)
+		if err := r.deleteOrphan(candidate); err != nil {
+			r.Log.Error(err, "could not delete orphaned object", "kind", kind, "name", candidate.GetName())
+		}
+	}
+
+	return nil
+}
+
+// candidates lists the objects the controller creates that cost money when they're left behind
+func (r *OrphanReaper) candidates() ([]orphanCandidate, error) {
+	candidates := []orphanCandidate{}
+
+	argoCDApplications := &argocdapplicationv1alpha1.ApplicationList{}
+	if err := r.Client.List(context.Background(), argoCDApplications, client.InNamespace(r.ArgoCDNamespace)); err != nil {
+		return nil, err
+	}
+	for i := range argoCDApplications.Items {
+		candidates = append(candidates, &argoCDApplications.Items[i])
+	}
+
+	nodePools := &crossplanegcpv1alpha1.NodePoolList{}
+	if err := r.Client.List(context.Background(), nodePools); err != nil {
+		return nil, err
+	}
+	for i := range nodePools.Items {
+		candidates = append(candidates, &nodePools.Items[i])
+	}
+
+	claims := &computev1alpha1.KubernetesClusterList{}
+	if err := r.Client.List(context.Background(), claims, client.InNamespace(r.CrossplaneNamespace)); err != nil {
+		return nil, err
+	}
+	for i := range claims.Items {
+		candidates = append(candidates, &claims.Items[i])
+	}
+
+	return candidates, nil
+}
+
+// environmentUID returns the UID of the environment the object was created for. Objects created
+// before they were labelled with the UID fall back to their owner reference.
+func environmentUID(obj metav1.Object) (types.UID, bool) {
+	if uid := obj.GetLabels()[EnvironmentUIDLabel]; uid != "" {
+		return types.UID(uid), true
+	}
+
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != "Environment" || owner.APIVersion != devv1alpha1.GroupVersion.String() {
+		return "", false
+	}
+
+	return owner.UID, true
+}
+
+// deleteOrphan deletes an orphaned object, argocd applications along with the resources they deployed
+func (r *OrphanReaper) deleteOrphan(candidate orphanCandidate) error {
+	if argoCDApplication, ok := candidate.(*argocdapplicationv1alpha1.Application); ok {
+		return r.deleteArgoCDApp(argoCDApplication)
+	}
+
+	if err := r.Client.Delete(context.Background(), candidate); err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
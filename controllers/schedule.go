@@ -0,0 +1,319 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	crossplaneruntime "github.com/crossplane/crossplane-runtime/apis/core/v1alpha1"
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/robfig/cron"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// ReplicasBeforeSleepAnnotation holds the replicas a workload had before its environment went to sleep
+const ReplicasBeforeSleepAnnotation = "dev.vadasambar.github.io/replicas-before-sleep"
+
+// scheduleLookback is how far back the last wake and sleep of a window are searched
+const scheduleLookback = 8 * 24 * time.Hour
+
+// isAwake returns true if the time is inside one of the windows of the schedule.
+// Environments without a schedule are always awake.
+func isAwake(schedule *devv1alpha1.ScheduleSpec, now time.Time) (bool, error) {
+	if schedule == nil || len(schedule.Windows) == 0 {
+		return true, nil
+	}
+
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return false, fmt.Errorf("invalid schedule timezone '%s': %v", schedule.Timezone, err)
+	}
+	now = now.In(location)
+
+	for _, window := range schedule.Windows {
+		wake, err := cron.ParseStandard(window.Wake)
+		if err != nil {
+			return false, fmt.Errorf("invalid schedule wake '%s': %v", window.Wake, err)
+		}
+		sleep, err := cron.ParseStandard(window.Sleep)
+		if err != nil {
+			return false, fmt.Errorf("invalid schedule sleep '%s': %v", window.Sleep, err)
+		}
+
+		lastWake := lastOccurrence(wake, now)
+		if !lastWake.IsZero() && lastWake.After(lastOccurrence(sleep, now)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// lastOccurrence returns the last time the schedule fired before now, zero if it didn't within the lookback
+func lastOccurrence(schedule cron.Schedule, now time.Time) time.Time {
+	last := time.Time{}
+	for next := schedule.Next(now.Add(-scheduleLookback)); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		last = next
+	}
+
+	return last
+}
+
+// handleSleeping scales the environment down when it enters the phase, outside the windows of its
+// schedule, once it's idle or once it exhausted its budget. The TTL keeps running while it sleeps.
+func (r *EnvironmentReconciler) handleSleeping(pc *phaseContext) (devv1alpha1.EnvironmentPhase, error) {
+	env := pc.env
+	if env.Status.Phase != devv1alpha1.PhaseSleeping {
+		r.Log.Info("environment is outside the active windows of its schedule, idle or over budget, putting it to sleep", "environment", env.GetName())
+		if err := r.sleep(pc); err != nil {
+			r.Log.Error(err, "could not put the environment to sleep")
+			return devv1alpha1.PhaseSleeping, err
+		}
+	}
+
+	if err := r.extendTTL(env); err != nil {
+		return devv1alpha1.PhaseSleeping, err
+	}
+
+	if env.Spec.TTL == "" {
+		env.Status.ExpiresAt = nil
+		return devv1alpha1.PhaseSleeping, nil
+	}
+	// the TTL starts once the environment is ready, and is suspended while sleeping like while ready,
+	// so waking up doesn't shorten or lengthen it
+	if env.Status.TTLStartTimestamp.IsZero() || suspendTTL(env, metav1.Now()) {
+		return devv1alpha1.PhaseSleeping, nil
+	}
+
+	expiresAt := metav1.NewTime(ttlExpiresAt(env))
+	env.Status.ExpiresAt = &expiresAt
+	if time.Now().UTC().After(expiresAt.Time) {
+		return r.deleteExpired(env)
+	}
+
+	// sleeping environments expire like ready ones, so their owners are warned too
+	r.warnBeforeExpiry(env, expiresAt.Time)
+	return devv1alpha1.PhaseSleeping, nil
+}
+
+// sleep scales the cluster or the workloads of the environment down
+func (r *EnvironmentReconciler) sleep(pc *phaseContext) error {
+	if sleeper, ok := pc.provider.(ClusterSleeper); ok {
+		return sleeper.SleepCluster(pc.env)
+	}
+
+	return r.sleepWorkloads(pc.env)
+}
+
+// wake undoes sleep, the argocd applications get their auto-sync back when they're patched again
+func (r *EnvironmentReconciler) wake(pc *phaseContext) error {
+	r.Log.Info("environment is awake again, waking it up", "environment", pc.env.GetName())
+	if sleeper, ok := pc.provider.(ClusterSleeper); ok {
+		return sleeper.WakeCluster(pc.env)
+	}
+
+	return r.wakeWorkloads(pc.env)
+}
+
+// sleepWorkloads suspends the auto-sync of the argocd applications of the environment, so argocd
+// doesn't undo the scale down, and scales the deployments and statefulsets they deploy to to zero
+func (r *EnvironmentReconciler) sleepWorkloads(env *devv1alpha1.Environment) error {
+	namespaces, err := r.suspendAutoSync(env)
+	if err != nil {
+		return err
+	}
+
+	return r.scaleWorkloads(env, namespaces, func(replicas int32, annotations map[string]string) (int32, bool) {
+		if replicas == 0 {
+			return 0, false
+		}
+		annotations[ReplicasBeforeSleepAnnotation] = strconv.Itoa(int(replicas))
+		return 0, true
+	})
+}
+
+// wakeWorkloads scales the deployments and statefulsets back to the replicas they had before sleeping
+func (r *EnvironmentReconciler) wakeWorkloads(env *devv1alpha1.Environment) error {
+	namespaces := []string{}
+	for _, app := range r.environmentApps(env) {
+		namespaces = appendMissing(namespaces, app.Spec.Destination.Namespace)
+	}
+
+	return r.scaleWorkloads(env, namespaces, func(replicas int32, annotations map[string]string) (int32, bool) {
+		before, ok := annotations[ReplicasBeforeSleepAnnotation]
+		if !ok {
+			return replicas, false
+		}
+		delete(annotations, ReplicasBeforeSleepAnnotation)
+		restored, err := strconv.Atoi(before)
+		if err != nil {
+			return replicas, true
+		}
+		return int32(restored), true
+	})
+}
+
+// suspendAutoSync removes the sync policy of the argocd applications and returns the namespaces they deploy to
+func (r *EnvironmentReconciler) suspendAutoSync(env *devv1alpha1.Environment) ([]string, error) {
+	namespaces := []string{}
+	for _, app := range r.environmentApps(env) {
+		namespaces = appendMissing(namespaces, app.Spec.Destination.Namespace)
+		if app.Spec.SyncPolicy == nil {
+			continue
+		}
+
+		patch := client.MergeFrom(app.DeepCopy())
+		app.Spec.SyncPolicy = nil
+		if err := r.Client.Patch(context.Background(), app, patch); err != nil {
+			r.Log.Error(err, "could not suspend auto-sync of the argocd application", "application", app.GetName())
+			return nil, err
+		}
+	}
+
+	return namespaces, nil
+}
+
+// environmentApps returns the argocd applications of the environment that exist
+func (r *EnvironmentReconciler) environmentApps(env *devv1alpha1.Environment) []*argocdapplicationv1alpha1.Application {
+	apps := []*argocdapplicationv1alpha1.Application{}
+	for _, ref := range env.Status.Applications {
+		app := &argocdapplicationv1alpha1.Application{}
+		if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: r.ArgoCDNamespace, Name: ref.ApplicationName}, app); err != nil {
+			continue
+		}
+		apps = append(apps, app)
+	}
+
+	return apps
+}
+
+// scaleWorkloads updates the replicas of the deployments and statefulsets in the namespaces.
+// scale returns the new replicas and whether the workload has to be updated, it can change the annotations.
+func (r *EnvironmentReconciler) scaleWorkloads(env *devv1alpha1.Environment, namespaces []string, scale func(replicas int32, annotations map[string]string) (int32, bool)) error {
+	clusterClient, err := r.clusterClient(env)
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		deployments := &appsv1.DeploymentList{}
+		if err := clusterClient.List(context.Background(), deployments, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		for i := range deployments.Items {
+			if err := scaleWorkload(clusterClient, &deployments.Items[i], &deployments.Items[i].Spec.Replicas, scale); err != nil {
+				return err
+			}
+		}
+
+		statefulSets := &appsv1.StatefulSetList{}
+		if err := clusterClient.List(context.Background(), statefulSets, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		for i := range statefulSets.Items {
+			if err := scaleWorkload(clusterClient, &statefulSets.Items[i], &statefulSets.Items[i].Spec.Replicas, scale); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func scaleWorkload(clusterClient client.Client, workload workloadObject, replicas **int32, scale func(int32, map[string]string) (int32, bool)) error {
+	current := int32(1)
+	if *replicas != nil {
+		current = **replicas
+	}
+
+	patch := client.MergeFrom(workload.DeepCopyObject())
+	annotations := workload.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	scaled, changed := scale(current, annotations)
+	if !changed {
+		return nil
+	}
+
+	workload.SetAnnotations(annotations)
+	*replicas = &scaled
+	return clusterClient.Patch(context.Background(), workload, patch)
+}
+
+// workloadObject is a deployment or a statefulset
+type workloadObject interface {
+	runtime.Object
+	GetAnnotations() map[string]string
+	SetAnnotations(map[string]string)
+}
+
+// clusterClient returns a client of the cluster the applications of the environment are deployed to.
+// Remote clusters are reached through their connection secret.
+func (r *EnvironmentReconciler) clusterClient(env *devv1alpha1.Environment) (client.Client, error) {
+	if env.Spec.Isolation == devv1alpha1.IsolationNamespace {
+		return r.Client, nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: r.CrossplaneNamespace, Name: env.Spec.ClusterName}, secret); err != nil {
+		return nil, fmt.Errorf("could not get connection secret of cluster '%s': %v", env.Spec.ClusterName, err)
+	}
+
+	config, err := restConfigFromConnectionSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(config, client.Options{Scheme: r.Scheme})
+}
+
+// restConfigFromConnectionSecret builds a rest config from the keys crossplane writes to cluster connection secrets
+func restConfigFromConnectionSecret(secret *corev1.Secret) (*rest.Config, error) {
+	if kubeconfig, ok := secret.Data[crossplaneruntime.ResourceCredentialsSecretKubeconfigKey]; ok && len(kubeconfig) > 0 {
+		return clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	}
+
+	endpoint := string(secret.Data[crossplaneruntime.ResourceCredentialsSecretEndpointKey])
+	if endpoint == "" {
+		return nil, fmt.Errorf("connection secret '%s' has no endpoint", secret.GetName())
+	}
+
+	return &rest.Config{
+		Host:        endpoint,
+		Username:    string(secret.Data[crossplaneruntime.ResourceCredentialsSecretUserKey]),
+		Password:    string(secret.Data[crossplaneruntime.ResourceCredentialsSecretPasswordKey]),
+		BearerToken: string(secret.Data[crossplaneruntime.ResourceCredentialsSecretTokenKey]),
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData:   secret.Data[crossplaneruntime.ResourceCredentialsSecretCAKey],
+			CertData: secret.Data[crossplaneruntime.ResourceCredentialsSecretClientCertKey],
+			KeyData:  secret.Data[crossplaneruntime.ResourceCredentialsSecretClientKeyKey],
+		},
+	}, nil
+}
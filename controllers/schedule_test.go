@@ -0,0 +1,141 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+func TestIsAwake(t *testing.T) {
+	officeHours := devv1alpha1.ScheduleWindow{Wake: "0 8 * * 1-5", Sleep: "0 19 * * 1-5"}
+	// awake from monday 22:00 to tuesday 06:00, and so on until saturday morning
+	nightShift := devv1alpha1.ScheduleWindow{Wake: "0 22 * * 1-5", Sleep: "0 6 * * 2-6"}
+	// wednesday, 15 january 2020
+	wednesday := func(hour, minute int) time.Time {
+		return time.Date(2020, 1, 15, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		schedule  *devv1alpha1.ScheduleSpec
+		now       time.Time
+		wantAwake bool
+		wantErr   bool
+	}{
+		{
+			name:      "environment without a schedule is awake",
+			now:       wednesday(3, 0),
+			wantAwake: true,
+		},
+		{
+			name:      "inside office hours",
+			schedule:  &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{officeHours}},
+			now:       wednesday(12, 0),
+			wantAwake: true,
+		},
+		{
+			name:     "after office hours",
+			schedule: &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{officeHours}},
+			now:      wednesday(19, 30),
+		},
+		{
+			name:     "weekend",
+			schedule: &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{officeHours}},
+			now:      time.Date(2020, 1, 18, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "overnight window before midnight",
+			schedule:  &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{nightShift}},
+			now:       wednesday(23, 0),
+			wantAwake: true,
+		},
+		{
+			name:      "overnight window after midnight",
+			schedule:  &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{nightShift}},
+			now:       wednesday(2, 0),
+			wantAwake: true,
+		},
+		{
+			name:     "overnight window during the day",
+			schedule: &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{nightShift}},
+			now:      wednesday(12, 0),
+		},
+		{
+			name:      "overnight window ending on saturday morning",
+			schedule:  &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{nightShift}},
+			now:       time.Date(2020, 1, 18, 5, 0, 0, 0, time.UTC),
+			wantAwake: true,
+		},
+		{
+			name:     "overnight window doesn't start on saturday evening",
+			schedule: &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{nightShift}},
+			now:      time.Date(2020, 1, 18, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "any window keeps the environment awake",
+			schedule:  &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{officeHours, nightShift}},
+			now:       wednesday(23, 0),
+			wantAwake: true,
+		},
+		{
+			// 07:30 UTC is 08:30 in Berlin
+			name:      "windows are evaluated in the timezone",
+			schedule:  &devv1alpha1.ScheduleSpec{Timezone: "Europe/Berlin", Windows: []devv1alpha1.ScheduleWindow{officeHours}},
+			now:       wednesday(7, 30),
+			wantAwake: true,
+		},
+		{
+			// 18:30 UTC is 19:30 in Berlin
+			name:     "window ends in the timezone",
+			schedule: &devv1alpha1.ScheduleSpec{Timezone: "Europe/Berlin", Windows: []devv1alpha1.ScheduleWindow{officeHours}},
+			now:      wednesday(18, 30),
+		},
+		{
+			// wednesday 01:00 UTC is tuesday 20:00 in New York
+			name:     "timezone west of UTC is still on the previous day",
+			schedule: &devv1alpha1.ScheduleSpec{Timezone: "America/New_York", Windows: []devv1alpha1.ScheduleWindow{nightShift}},
+			now:      wednesday(1, 0),
+		},
+		{
+			name:     "invalid timezone",
+			schedule: &devv1alpha1.ScheduleSpec{Timezone: "Mars/Olympus_Mons", Windows: []devv1alpha1.ScheduleWindow{officeHours}},
+			now:      wednesday(12, 0),
+			wantErr:  true,
+		},
+		{
+			name:     "invalid cron expression",
+			schedule: &devv1alpha1.ScheduleSpec{Windows: []devv1alpha1.ScheduleWindow{{Wake: "every morning", Sleep: "0 19 * * *"}}},
+			now:      wednesday(12, 0),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			awake, err := isAwake(tt.schedule, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if awake != tt.wantAwake {
+				t.Errorf("got awake %t, want %t", awake, tt.wantAwake)
+			}
+		})
+	}
+}
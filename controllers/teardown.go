@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	computev1alpha1 "github.com/crossplane/crossplane/apis/compute/v1alpha1"
+	argocdapplicationv1alpha1 "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// teardownStep deletes a part of a deleted environment, guarded by its own finalizer
+type teardownStep struct {
+	finalizer string
+	step      devv1alpha1.TeardownStep
+	// delete deletes the objects of the step and returns the ones that still exist
+	delete func(env *devv1alpha1.Environment) ([]string, error)
+}
+
+// teardownSteps returns the steps needed to delete an environment of the provider in the order they run
+func (r *EnvironmentReconciler) teardownSteps(provider ClusterProvider) []teardownStep {
+	steps := []teardownStep{
+		{EnvironmentFinalizer, devv1alpha1.TeardownDeletingApplications, r.deleteApps},
+	}
+
+	if deleter, ok := provider.(NodePoolDeleter); ok {
+		steps = append(steps, teardownStep{GCPNodePoolFinalizer, devv1alpha1.TeardownDeletingNodePools, deleter.DeleteNodePools})
+	}
+
+	switch provider.(type) {
+	case *gkeClusterProvider, *eksClusterProvider, *aksClusterProvider:
+		steps = append(steps, teardownStep{ClusterClaimFinalizer, devv1alpha1.TeardownDeletingClusterClaim, r.deleteClusterClaim})
+	}
+
+	if finalizer, ok := provider.(ClusterFinalizer); ok {
+		steps = append(steps, teardownStep{finalizer.Finalizer(), devv1alpha1.TeardownDeletingCluster, func(env *devv1alpha1.Environment) ([]string, error) {
+			return nil, finalizer.DeleteCluster(env)
+		}})
+	}
+
+	return steps
+}
+
+// addFinalizers adds the finalizers of the teardown steps the environment doesn't have yet
+func (r *EnvironmentReconciler) addFinalizers(pc *phaseContext) error {
+	env := pc.env
+	added := false
+	for _, step := range r.teardownSteps(pc.provider) {
+		if !containsString(env.ObjectMeta.Finalizers, step.finalizer) {
+			env.ObjectMeta.Finalizers = append(env.ObjectMeta.Finalizers, step.finalizer)
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+
+	status := env.Status
+	if err := r.Update(context.Background(), env); err != nil {
+		r.Log.Error(err, "could not add finalizers to the environment", "finalizers", env.ObjectMeta.Finalizers)
+		return err
+	}
+
+	// the update overwrote the status with the stored one
+	env.Status = status
+	return nil
+}
+
+// removeFinalizer removes the finalizer of a finished teardown step
+func (r *EnvironmentReconciler) removeFinalizer(env *devv1alpha1.Environment, finalizer string) error {
+	status := env.Status
+	env.ObjectMeta.Finalizers = removeString(env.ObjectMeta.Finalizers, finalizer)
+	if err := r.Update(context.Background(), env); err != nil && !kerrors.IsNotFound(err) {
+		r.Log.Error(err, "could not remove finalizer from the environment", "finalizer", finalizer)
+		return err
+	}
+
+	env.Status = status
+	return nil
+}
+
+// deleteApps deletes the argocd applications of the environment with cascaded deletion,
+// so the load balancers and volumes their resources hold are released before the cluster goes away
+func (r *EnvironmentReconciler) deleteApps(env *devv1alpha1.Environment) ([]string, error) {
+	argoCDApplications := &argocdapplicationv1alpha1.ApplicationList{}
+	if err := r.Client.List(context.Background(), argoCDApplications,
+		client.InNamespace(r.ArgoCDNamespace),
+		client.MatchingLabels{EnvironmentNameLabel: env.GetName()}); err != nil {
+		return nil, err
+	}
+
+	remaining := []string{}
+	for i := range argoCDApplications.Items {
+		argoCDApplication := &argoCDApplications.Items[i]
+		if !metav1.IsControlledBy(argoCDApplication, env) {
+			continue
+		}
+
+		remaining = append(remaining, argoCDApplication.GetName())
+		if !argoCDApplication.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		r.Log.Info("deleting argocd application of the deleted environment", "application", argoCDApplication.GetName())
+		if err := r.deleteArgoCDApp(argoCDApplication); err != nil {
+			return nil, err
+		}
+	}
+
+	return remaining, nil
+}
+
+// deleteClusterClaim deletes the KubernetesCluster claim of the environment,
+// crossplane removes the claim once the managed cluster is deleted or released
+func (r *EnvironmentReconciler) deleteClusterClaim(env *devv1alpha1.Environment) ([]string, error) {
+	claim := &computev1alpha1.KubernetesCluster{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: r.CrossplaneNamespace, Name: env.Spec.ClusterName}, claim); err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(claim, env) {
+		return nil, nil
+	}
+
+	if claim.GetDeletionTimestamp().IsZero() {
+		r.Log.Info("deleting the cluster claim of the deleted environment", "cluster-name", env.Spec.ClusterName)
+		if err := r.Client.Delete(context.Background(), claim); err != nil && !kerrors.IsNotFound(err) {
+			r.Log.Error(err, "could not delete the cluster claim", "cluster claim", claim.GetName(), "namespace", claim.GetNamespace())
+			return nil, err
+		}
+	}
+
+	return []string{claim.GetName()}, nil
+}
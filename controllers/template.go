@@ -0,0 +1,179 @@
+/*
+Copyright 2019 Suraj Banakar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	devv1alpha1 "devenv-controller/api/v1alpha1"
+)
+
+// templateParameterRegexp matches the `$(name)` parameter references of a template
+var templateParameterRegexp = regexp.MustCompile(`\$\(([a-zA-Z][a-zA-Z0-9_-]*)\)`)
+
+// renderTemplate fills the empty fields of the spec from the template the environment references
+// and records the template generation used. Environments are only rendered once, later changes of
+// the template are reported by the TemplateUpToDate condition instead.
+func (r *EnvironmentReconciler) renderTemplate(env *devv1alpha1.Environment) error {
+	ref := env.Spec.TemplateRef
+	if ref == nil || (env.Status.Template != nil && env.Status.Template.Name == ref.Name) {
+		return nil
+	}
+
+	template := &devv1alpha1.EnvironmentTemplate{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Name: ref.Name}, template); err != nil {
+		return fmt.Errorf("could not get environment template '%s': %v", ref.Name, err)
+	}
+
+	rendered, renderErr := renderTemplateSpec(template, env)
+	if renderErr != nil {
+		return fmt.Errorf("could not render environment template '%s': %v", ref.Name, renderErr)
+	}
+	applyTemplateSpec(&env.Spec, rendered)
+	if env.Spec.ClusterName == "" {
+		env.Spec.ClusterName = env.GetName()
+	}
+	env.Default()
+
+	r.Log.Info("rendering environment from template", "template", ref.Name, "generation", template.GetGeneration())
+	status := env.Status
+	if err := r.Update(context.Background(), env); err != nil {
+		r.Log.Error(err, "could not update the environment rendered from the template", "template", ref.Name)
+		return err
+	}
+
+	// the update overwrote the status with the stored one
+	env.Status = status
+	env.Status.Template = &devv1alpha1.TemplateStatus{
+		Name:       ref.Name,
+		Generation: template.GetGeneration(),
+	}
+
+	return nil
+}
+
+// renderTemplateSpec substitutes the parameters of the environment into the template
+func renderTemplateSpec(template *devv1alpha1.EnvironmentTemplate, env *devv1alpha1.Environment) (devv1alpha1.EnvironmentSpec, error) {
+	values := map[string]string{
+		devv1alpha1.TemplateEnvironmentParameter: env.GetName(),
+	}
+	for _, parameter := range template.Spec.Parameters {
+		value, ok := env.Spec.TemplateRef.Parameters[parameter.Name]
+		if !ok && parameter.Required {
+			return devv1alpha1.EnvironmentSpec{}, fmt.Errorf("parameter '%s' is required", parameter.Name)
+		}
+		if !ok {
+			value = parameter.Default
+		}
+		values[parameter.Name] = value
+	}
+	for name := range env.Spec.TemplateRef.Parameters {
+		if _, ok := values[name]; !ok {
+			return devv1alpha1.EnvironmentSpec{}, fmt.Errorf("parameter '%s' is not declared by the template", name)
+		}
+	}
+
+	raw, err := json.Marshal(template.Spec.Template)
+	if err != nil {
+		return devv1alpha1.EnvironmentSpec{}, err
+	}
+
+	var substituteErr error
+	raw = templateParameterRegexp.ReplaceAllFunc(raw, func(reference []byte) []byte {
+		name := templateParameterRegexp.FindSubmatch(reference)[1]
+		value, ok := values[string(name)]
+		if !ok {
+			substituteErr = fmt.Errorf("template references undeclared parameter '%s'", name)
+			return reference
+		}
+
+		// the value is substituted inside a JSON string, so it has to be escaped like one
+		quoted, _ := json.Marshal(value)
+		return quoted[1 : len(quoted)-1]
+	})
+	if substituteErr != nil {
+		return devv1alpha1.EnvironmentSpec{}, substituteErr
+	}
+
+	spec := devv1alpha1.EnvironmentSpec{}
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return devv1alpha1.EnvironmentSpec{}, err
+	}
+
+	return spec, nil
+}
+
+// applyTemplateSpec fills the fields the environment doesn't set from the rendered template
+func applyTemplateSpec(spec *devv1alpha1.EnvironmentSpec, template devv1alpha1.EnvironmentSpec) {
+	if spec.Source == nil && len(spec.Sources) == 0 {
+		spec.Source = template.Source
+		spec.Sources = template.Sources
+	}
+	if len(spec.Dependencies) == 0 {
+		spec.Dependencies = template.Dependencies
+	}
+	if spec.ClusterClassLabel == "" {
+		spec.ClusterClassLabel = template.ClusterClassLabel
+	}
+	if spec.ClusterName == "" {
+		spec.ClusterName = template.ClusterName
+	}
+	if spec.Provider == "" {
+		spec.Provider = template.Provider
+	}
+	if spec.Isolation == "" {
+		spec.Isolation = template.Isolation
+	}
+	if spec.NamespaceIsolation == nil {
+		spec.NamespaceIsolation = template.NamespaceIsolation
+	}
+	if len(spec.NodePools) == 0 {
+		spec.NodePools = template.NodePools
+	}
+	if spec.TTL == "" {
+		spec.TTL = template.TTL
+	}
+}
+
+// setTemplateCondition reports whether the template changed since the environment was rendered from it
+func (r *EnvironmentReconciler) setTemplateCondition(env *devv1alpha1.Environment, setCondition func(devv1alpha1.ConditionType, bool, string, string)) {
+	if env.Status.Template == nil {
+		devv1alpha1.RemoveCondition(&env.Status.Conditions, devv1alpha1.ConditionTemplateUpToDate)
+		return
+	}
+
+	name := env.Status.Template.Name
+	template := &devv1alpha1.EnvironmentTemplate{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Name: name}, template); err != nil {
+		setCondition(devv1alpha1.ConditionTemplateUpToDate, false, "TemplateNotFound", fmt.Sprintf("could not get environment template '%s': %v", name, err))
+		return
+	}
+
+	if template.GetGeneration() != env.Status.Template.Generation {
+		setCondition(devv1alpha1.ConditionTemplateUpToDate, false, "TemplateChanged", fmt.Sprintf(
+			"environment template '%s' changed since the environment was rendered from it (generation %d, now %d)",
+			name, env.Status.Template.Generation, template.GetGeneration()))
+		return
+	}
+
+	setCondition(devv1alpha1.ConditionTemplateUpToDate, true, "UpToDate", fmt.Sprintf("environment was rendered from the latest generation of template '%s'", name))
+}
@@ -18,8 +18,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	devv1alpha1 "devenv-controller/api/v1alpha1"
 
@@ -32,6 +34,7 @@ import (
 	providergcpapis "github.com/crossplane/provider-gcp/apis"
 	argocdapplicationapis "github.com/kanuahs/argo-cd/pkg/apis/application/v1alpha1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -56,9 +59,26 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var enableWebhooks bool
+	var ttlWarnings string
+	var smtpConfig controllers.SMTPConfig
+	var reaperInterval time.Duration
+	var reaperDryRun bool
+	var priceTable string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8085", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable the admission webhooks for environments. Requires serving certificates, check config/certmanager.")
+	flag.StringVar(&ttlWarnings, "ttl-warnings", "1h,10m",
+		"Comma separated times before the TTL of an environment expires a warning is sent, unless the environment sets `spec.notify.beforeExpiry`.")
+	flag.StringVar(&smtpConfig.Addr, "smtp-addr", "", "The host:port of the SMTP server used for email notifications. Email notifications are disabled when empty.")
+	flag.StringVar(&smtpConfig.From, "smtp-from", "dev-env@localhost", "The sender of email notifications.")
+	flag.DurationVar(&reaperInterval, "reaper-interval", 10*time.Minute,
+		"How often claims, node pools and argocd applications of environments that don't exist anymore are deleted. 0 disables the reaper.")
+	flag.BoolVar(&reaperDryRun, "reaper-dry-run", false, "Only report orphaned objects through logs and events instead of deleting them.")
+	flag.StringVar(&priceTable, "price-table", "",
+		"The <namespace>/<name> of the ConfigMap mapping machine types to their price per hour. Environments aren't priced when empty.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
@@ -75,6 +95,32 @@ func main() {
 		argocdNs = ns
 	}
 
+	ttlWarningThresholds := []time.Duration{}
+	for _, threshold := range strings.Split(ttlWarnings, ",") {
+		if strings.TrimSpace(threshold) == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(threshold))
+		if err != nil {
+			setupLog.Error(err, "invalid ttl warning", "ttl-warnings", ttlWarnings)
+			os.Exit(1)
+		}
+		ttlWarningThresholds = append(ttlWarningThresholds, duration)
+	}
+
+	priceTableName := types.NamespacedName{}
+	if priceTable != "" {
+		parts := strings.SplitN(priceTable, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected <namespace>/<name>"), "invalid price table", "price-table", priceTable)
+			os.Exit(1)
+		}
+		priceTableName = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+
+	smtpConfig.Username = os.Getenv("SMTP-USERNAME")
+	smtpConfig.Password = os.Getenv("SMTP-PASSWORD")
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
@@ -86,16 +132,37 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.EnvironmentReconciler{
+	reconciler := &controllers.EnvironmentReconciler{
 		Client:              mgr.GetClient(),
 		Log:                 ctrl.Log.WithName("controllers").WithName("Environment"),
 		Scheme:              mgr.GetScheme(),
 		CrossplaneNamespace: crossplaneNs,
 		ArgoCDNamespace:     argocdNs,
-	}).SetupWithManager(mgr); err != nil {
+		Recorder:            mgr.GetEventRecorderFor("environment-controller"),
+		TTLWarnings:         ttlWarningThresholds,
+		SMTP:                smtpConfig,
+		PriceTable:          priceTableName,
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Environment")
 		os.Exit(1)
 	}
+	if reaperInterval > 0 {
+		if err = mgr.Add(&controllers.OrphanReaper{
+			EnvironmentReconciler: reconciler,
+			Interval:              reaperInterval,
+			DryRun:                reaperDryRun,
+		}); err != nil {
+			setupLog.Error(err, "unable to create orphan reaper")
+			os.Exit(1)
+		}
+	}
+	if enableWebhooks {
+		if err = (&devv1alpha1.Environment{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Environment")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")